@@ -0,0 +1,308 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// normalizeCoveragePath canonicalizes a path reported by a coverage artifact,
+// or requested by a lookup, to the same repo-root-relative form: Cobertura,
+// JaCoCo and LCOV each report paths in their own namespace, and a mismatched
+// "./" or "\" is enough to make a stored path never match a lookup for the
+// same file.
+func normalizeCoveragePath(p string) string {
+	p = strings.ReplaceAll(p, `\`, "/")
+	p = path.Clean(p)
+	p = strings.TrimPrefix(p, "/")
+	p = strings.TrimPrefix(p, "./")
+
+	return p
+}
+
+// fileLineHits maps a file path to a map of line number -> hit count, as
+// reported by a single coverage artifact.
+type fileLineHits map[string]map[int]int
+
+// FileCoverage is the merged, per-commit coverage for a single file: the
+// highest hit count observed for each line across every job that reported
+// on it. A line present with a hit count of 0 is an executable line that no
+// job managed to cover.
+type FileCoverage struct {
+	Lines map[int]int `json:"lines"`
+}
+
+// uncoveredLines returns the line numbers in fc that no job covered.
+func (fc *FileCoverage) uncoveredLines() map[int]bool {
+	uncovered := make(map[int]bool)
+	if fc == nil {
+		return uncovered
+	}
+
+	for line, hits := range fc.Lines {
+		if hits == 0 {
+			uncovered[line] = true
+		}
+	}
+
+	return uncovered
+}
+
+// lineCount returns the total number of executable lines reported across
+// every file in hits, used as the weight for the weighted-mean aggregation.
+func (hits fileLineHits) lineCount() int {
+	count := 0
+	for _, lines := range hits {
+		count += len(lines)
+	}
+
+	return count
+}
+
+// fetchJobArtifactCoverage downloads jobID's artifacts archive and parses
+// whichever Cobertura, JaCoCo, or LCOV reports it can find in it. A job that
+// didn't publish a coverage report artifact is not an error: it simply
+// contributes nothing.
+func fetchJobArtifactCoverage(ctx context.Context, projectID, jobID int) (fileLineHits, error) {
+	start := time.Now()
+	reader, resp, err := git.Jobs.GetJobArtifacts(projectID, jobID)
+	observeGitlabAPICall("GetJobArtifacts", start, err)
+	if err != nil {
+		if resp != nil && resp.StatusCode == 404 {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("download job artifacts: %s", err)
+	}
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("read job artifacts: %s", err)
+	}
+
+	return parseArtifactsArchive(data)
+}
+
+// parseArtifactsArchive extracts every coverage report it recognizes from a
+// job's artifacts zip and merges them into a single fileLineHits.
+func parseArtifactsArchive(data []byte) (fileLineHits, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("open artifacts archive: %s", err)
+	}
+
+	merged := fileLineHits{}
+
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		report, err := parseCoverageReport(f.Name, f)
+		if err != nil || report == nil {
+			continue
+		}
+
+		mergeFileLineHits(merged, report)
+	}
+
+	return merged, nil
+}
+
+func parseCoverageReport(name string, f *zip.File) (fileLineHits, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("open %s in artifacts archive: %s", name, err)
+	}
+	defer rc.Close()
+
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("read %s in artifacts archive: %s", name, err)
+	}
+
+	lowerName := strings.ToLower(name)
+
+	switch {
+	case strings.HasSuffix(lowerName, ".info") || strings.Contains(lowerName, "lcov"):
+		return parseLCOV(data)
+	case strings.Contains(lowerName, "jacoco"):
+		return parseJacocoXML(data)
+	case strings.HasSuffix(lowerName, ".xml"):
+		return parseCoberturaXML(data)
+	default:
+		return nil, nil
+	}
+}
+
+// coberturaReport mirrors the subset of the Cobertura XML schema needed to
+// pull out per-file, per-line hit counts.
+type coberturaReport struct {
+	Packages []struct {
+		Classes []struct {
+			Filename string `xml:"filename,attr"`
+			Lines    []struct {
+				Number int `xml:"number,attr"`
+				Hits   int `xml:"hits,attr"`
+			} `xml:"lines>line"`
+		} `xml:"classes>class"`
+	} `xml:"packages>package"`
+}
+
+func parseCoberturaXML(data []byte) (fileLineHits, error) {
+	var report coberturaReport
+	if err := xml.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("parse cobertura report: %s", err)
+	}
+
+	hits := fileLineHits{}
+	for _, pkg := range report.Packages {
+		for _, class := range pkg.Classes {
+			filePath := normalizeCoveragePath(class.Filename)
+
+			fileHits := hits[filePath]
+			if fileHits == nil {
+				fileHits = map[int]int{}
+				hits[filePath] = fileHits
+			}
+
+			for _, line := range class.Lines {
+				fileHits[line.Number] += line.Hits
+			}
+		}
+	}
+
+	return hits, nil
+}
+
+// jacocoReport mirrors the subset of the JaCoCo XML schema needed to pull
+// out per-file, per-line coverage. JaCoCo reports instructions covered
+// ("ci") rather than hit counts, so a nonzero ci is treated as one hit.
+type jacocoReport struct {
+	Packages []struct {
+		Name        string `xml:"name,attr"`
+		SourceFiles []struct {
+			Name  string `xml:"name,attr"`
+			Lines []struct {
+				Number int `xml:"nr,attr"`
+				Missed int `xml:"mi,attr"`
+				Hit    int `xml:"ci,attr"`
+			} `xml:"line"`
+		} `xml:"sourcefile"`
+	} `xml:"package"`
+}
+
+func parseJacocoXML(data []byte) (fileLineHits, error) {
+	var report jacocoReport
+	if err := xml.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("parse jacoco report: %s", err)
+	}
+
+	hits := fileLineHits{}
+	for _, pkg := range report.Packages {
+		for _, src := range pkg.SourceFiles {
+			filePath := src.Name
+			if len(pkg.Name) > 0 {
+				filePath = pkg.Name + "/" + src.Name
+			}
+			filePath = normalizeCoveragePath(filePath)
+
+			fileHits := hits[filePath]
+			if fileHits == nil {
+				fileHits = map[int]int{}
+				hits[filePath] = fileHits
+			}
+
+			for _, line := range src.Lines {
+				if line.Hit > 0 {
+					fileHits[line.Number] = 1
+				} else if _, ok := fileHits[line.Number]; !ok {
+					fileHits[line.Number] = 0
+				}
+			}
+		}
+	}
+
+	return hits, nil
+}
+
+// parseLCOV parses the plain-text LCOV tracefile format: SF: starts a
+// record for a file, DA:<line>,<hits> reports a line's hit count, and
+// end_of_record closes it.
+func parseLCOV(data []byte) (fileLineHits, error) {
+	hits := fileLineHits{}
+
+	var currentFile string
+	var currentHits map[int]int
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch {
+		case strings.HasPrefix(line, "SF:"):
+			currentFile = normalizeCoveragePath(strings.TrimPrefix(line, "SF:"))
+			currentHits = map[int]int{}
+		case strings.HasPrefix(line, "DA:"):
+			if currentHits == nil {
+				continue
+			}
+
+			parts := strings.SplitN(strings.TrimPrefix(line, "DA:"), ",", 2)
+			if len(parts) != 2 {
+				continue
+			}
+
+			lineNumber, err := strconv.Atoi(parts[0])
+			if err != nil {
+				continue
+			}
+
+			lineHits, err := strconv.Atoi(parts[1])
+			if err != nil {
+				continue
+			}
+
+			currentHits[lineNumber] += lineHits
+		case line == "end_of_record":
+			if len(currentFile) > 0 {
+				hits[currentFile] = currentHits
+			}
+			currentFile = ""
+			currentHits = nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("parse lcov report: %s", err)
+	}
+
+	return hits, nil
+}
+
+// mergeFileLineHits folds src into dst, keeping the highest hit count seen
+// for each line so that a line covered by any job ends up covered overall.
+func mergeFileLineHits(dst, src fileLineHits) {
+	for path, lines := range src {
+		dstLines := dst[path]
+		if dstLines == nil {
+			dstLines = map[int]int{}
+			dst[path] = dstLines
+		}
+
+		for line, hits := range lines {
+			if hits > dstLines[line] {
+				dstLines[line] = hits
+			}
+		}
+	}
+}
+
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCoberturaXML(t *testing.T) {
+	data := []byte(`<?xml version="1.0"?>
+<coverage>
+  <packages>
+    <package>
+      <classes>
+        <class filename="./pkg/foo.go">
+          <lines>
+            <line number="1" hits="2"/>
+            <line number="2" hits="0"/>
+          </lines>
+        </class>
+      </classes>
+    </package>
+  </packages>
+</coverage>`)
+
+	hits, err := parseCoberturaXML(data)
+	if err != nil {
+		t.Fatalf("parseCoberturaXML() error = %v", err)
+	}
+
+	want := fileLineHits{"pkg/foo.go": {1: 2, 2: 0}}
+	if !reflect.DeepEqual(hits, want) {
+		t.Errorf("parseCoberturaXML() = %v, want %v", hits, want)
+	}
+}
+
+func TestParseJacocoXML(t *testing.T) {
+	data := []byte(`<?xml version="1.0"?>
+<report>
+  <package name="com/example/app">
+    <sourcefile name="Foo.java">
+      <line nr="1" mi="0" ci="3"/>
+      <line nr="2" mi="1" ci="0"/>
+    </sourcefile>
+  </package>
+</report>`)
+
+	hits, err := parseJacocoXML(data)
+	if err != nil {
+		t.Fatalf("parseJacocoXML() error = %v", err)
+	}
+
+	want := fileLineHits{"com/example/app/Foo.java": {1: 1, 2: 0}}
+	if !reflect.DeepEqual(hits, want) {
+		t.Errorf("parseJacocoXML() = %v, want %v", hits, want)
+	}
+}
+
+func TestParseLCOV(t *testing.T) {
+	data := []byte("SF:/builds/group/project/src/foo.c\n" +
+		"DA:1,4\n" +
+		"DA:2,0\n" +
+		"end_of_record\n")
+
+	hits, err := parseLCOV(data)
+	if err != nil {
+		t.Fatalf("parseLCOV() error = %v", err)
+	}
+
+	want := fileLineHits{"builds/group/project/src/foo.c": {1: 4, 2: 0}}
+	if !reflect.DeepEqual(hits, want) {
+		t.Errorf("parseLCOV() = %v, want %v", hits, want)
+	}
+}
+
+func TestMergeFileLineHits(t *testing.T) {
+	dst := fileLineHits{"foo.go": {1: 0, 2: 1}}
+	src := fileLineHits{
+		"foo.go": {1: 1, 2: 0},
+		"bar.go": {1: 5},
+	}
+
+	mergeFileLineHits(dst, src)
+
+	want := fileLineHits{
+		"foo.go": {1: 1, 2: 1},
+		"bar.go": {1: 5},
+	}
+	if !reflect.DeepEqual(dst, want) {
+		t.Errorf("mergeFileLineHits() = %v, want %v", dst, want)
+	}
+}
+
+func TestNormalizeCoveragePath(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{name: "already relative", path: "foo/bar.go", want: "foo/bar.go"},
+		{name: "leading dot slash", path: "./foo/bar.go", want: "foo/bar.go"},
+		{name: "leading slash", path: "/foo/bar.go", want: "foo/bar.go"},
+		{name: "backslashes", path: `foo\bar.go`, want: "foo/bar.go"},
+		{name: "redundant segments", path: "foo/./bar.go", want: "foo/bar.go"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeCoveragePath(tt.path); got != tt.want {
+				t.Errorf("normalizeCoveragePath(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+	"github.com/xanzy/go-gitlab"
+)
+
+const backfillDateLayout = "2006-01-02"
+
+func backfillCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "backfill",
+		Usage: "scan historical pipelines and populate the store without replaying webhook events",
+		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:     "project",
+				Usage:    "GitLab project ID",
+				Required: true,
+			},
+			&cli.TimestampFlag{
+				Name:     "since",
+				Usage:    "only scan pipelines created on or after this date (YYYY-MM-DD)",
+				Layout:   backfillDateLayout,
+				Required: true,
+			},
+		},
+		Action: runBackfill,
+	}
+}
+
+func runBackfill(c *cli.Context) error {
+	projectID := c.Int("project")
+	since := *c.Timestamp("since")
+
+	pipelines, err := listPipelinesSince(projectID, since)
+	if err != nil {
+		return fmt.Errorf("list pipelines: %s", err)
+	}
+
+	log.Info().
+		Int("project_id", projectID).
+		Int("pipelines", len(pipelines)).
+		Msg("Backfilling coverage from historical pipelines")
+
+	for _, pipeline := range pipelines {
+		if err := backfillPipeline(context.Background(), projectID, pipeline); err != nil {
+			log.Error().Err(err).Int("pipeline_id", pipeline.ID).Msg("Failed to backfill pipeline")
+		}
+	}
+
+	return nil
+}
+
+// listPipelinesSince returns every pipeline for projectID created on or
+// after since, newest first, paging through the API until it runs past it.
+func listPipelinesSince(projectID int, since time.Time) ([]*gitlab.PipelineInfo, error) {
+	var pipelines []*gitlab.PipelineInfo
+
+	opts := &gitlab.ListProjectPipelinesOptions{
+		ListOptions: gitlab.ListOptions{PerPage: 100},
+		OrderBy:     gitlab.String("id"),
+		Sort:        gitlab.String("desc"),
+	}
+
+	for {
+		start := time.Now()
+		page, resp, err := git.Pipelines.ListProjectPipelines(projectID, opts)
+		observeGitlabAPICall("ListProjectPipelines", start, err)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pipeline := range page {
+			if pipeline.CreatedAt != nil && pipeline.CreatedAt.Before(since) {
+				return pipelines, nil
+			}
+			pipelines = append(pipelines, pipeline)
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	return pipelines, nil
+}
+
+// backfillPipeline feeds the coverage reported by every successful job in
+// pipeline through the same code path a live "build" webhook would, then
+// finalizes the commit the same way a live "pipeline" webhook would: without
+// this, GetCoverage and the coverage history/badge endpoints would stay
+// empty for every backfilled commit, and reconcile-mr would have nothing to
+// report.
+func backfillPipeline(ctx context.Context, projectID int, pipeline *gitlab.PipelineInfo) error {
+	start := time.Now()
+	jobs, _, err := git.Jobs.ListPipelineJobs(projectID, pipeline.ID, &gitlab.ListJobsOptions{
+		Scope: []gitlab.BuildStateValue{gitlab.Success},
+	})
+	observeGitlabAPICall("ListPipelineJobs", start, err)
+	if err != nil {
+		return fmt.Errorf("list pipeline jobs: %s", err)
+	}
+
+	pipelineLog := log.With().
+		Int("project_id", projectID).
+		Str("sha", pipeline.SHA).
+		Logger()
+	pipelineCtx := pipelineLog.WithContext(ctx)
+
+	for _, job := range jobs {
+		jobLog := pipelineLog.With().Int("job", job.ID).Logger()
+
+		event := &gitlab.BuildEvent{
+			ProjectID:   projectID,
+			BuildID:     job.ID,
+			BuildStatus: job.Status,
+			SHA:         pipeline.SHA,
+		}
+
+		handleCommitCoverage(jobLog.WithContext(ctx), projectID, event)
+	}
+
+	if _, err := finalizeCommitCoverage(pipelineCtx, projectID, pipeline.SHA); err != nil {
+		return fmt.Errorf("finalize commit coverage: %s", err)
+	}
+
+	recordCommitMeta(pipelineCtx, projectID, pipeline.SHA, pipeline.Ref)
+
+	return nil
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltStore is the original Store implementation: a single bbolt file on
+// disk. It takes an exclusive file lock for as long as it's open, so only
+// one replica of the webhook receiver can run against a given boltDataPath.
+type boltStore struct {
+	db *bolt.DB
+}
+
+func newBoltStore(boltDataPath string) (*boltStore, error) {
+	db, err := bolt.Open(boltDataPath, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open bolt db %s: %s", boltDataPath, err)
+	}
+
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *boltStore) PutCoverage(ctx context.Context, projectID int, sha string, coverage float64) error {
+	return s.storeCommitData(projectID, sha, func(bucket *bolt.Bucket) error {
+		coverageStr := strconv.FormatFloat(coverage, 'f', -1, 64)
+		if err := bucket.Put([]byte("coverage"), []byte(coverageStr)); err != nil {
+			return fmt.Errorf("store commit coverage error: %s", err)
+		}
+
+		return nil
+	})
+}
+
+// GetCoverage returns 0 without error if no coverage is stored for sha.
+func (s *boltStore) GetCoverage(ctx context.Context, projectID int, sha string) (float64, error) {
+	var coverage float64
+
+	err := s.readFromCommitBucket(projectID, sha, func(commitBucket *bolt.Bucket) error {
+		coverageBytes := commitBucket.Get([]byte("coverage"))
+		if coverageBytes == nil {
+			return nil
+		}
+
+		var err error
+		coverage, err = strconv.ParseFloat(string(coverageBytes), 64)
+		if err != nil {
+			return fmt.Errorf("error while parsing coverage %q from db: %s", coverageBytes, err)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return coverage, nil
+}
+
+func (s *boltStore) PutCommitMeta(ctx context.Context, projectID int, sha, ref string, committedDate time.Time) error {
+	return s.storeCommitData(projectID, sha, func(bucket *bolt.Bucket) error {
+		if err := bucket.Put([]byte("ref"), []byte(ref)); err != nil {
+			return fmt.Errorf("store commit ref error: %s", err)
+		}
+
+		if err := bucket.Put([]byte("committed_date"), []byte(committedDate.Format(time.RFC3339Nano))); err != nil {
+			return fmt.Errorf("store commit committed_date error: %s", err)
+		}
+
+		return nil
+	})
+}
+
+// GetCoverageHistory scans every commit bucket recorded for projectID,
+// keeping the ones that match ref and were committed on or after since.
+// bbolt has no secondary index to look this up by, so a full per-project
+// scan is the cost of keeping the schema simple.
+func (s *boltStore) GetCoverageHistory(ctx context.Context, projectID int, ref string, since time.Time) ([]CoverageHistoryEntry, error) {
+	defer observeStoreTx("bolt", "get_coverage_history", time.Now())
+
+	var history []CoverageHistoryEntry
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		projectBucket := tx.Bucket([]byte(fmt.Sprintf("projects:%d", projectID)))
+		if projectBucket == nil {
+			return nil
+		}
+
+		return projectBucket.ForEach(func(key, value []byte) error {
+			if value != nil || !strings.HasPrefix(string(key), "sha:") {
+				return nil
+			}
+
+			commitBucket := projectBucket.Bucket(key)
+			if commitBucket == nil {
+				return nil
+			}
+
+			commitRef := string(commitBucket.Get([]byte("ref")))
+			if commitRef != ref {
+				return nil
+			}
+
+			committedDateBytes := commitBucket.Get([]byte("committed_date"))
+			if committedDateBytes == nil {
+				return nil
+			}
+
+			committedDate, err := time.Parse(time.RFC3339Nano, string(committedDateBytes))
+			if err != nil {
+				return fmt.Errorf("parse committed_date %q: %s", committedDateBytes, err)
+			}
+
+			if committedDate.Before(since) {
+				return nil
+			}
+
+			coverageBytes := commitBucket.Get([]byte("coverage"))
+			if coverageBytes == nil {
+				return nil
+			}
+
+			coverage, err := strconv.ParseFloat(string(coverageBytes), 64)
+			if err != nil {
+				return fmt.Errorf("parse coverage %q: %s", coverageBytes, err)
+			}
+
+			history = append(history, CoverageHistoryEntry{
+				SHA:       strings.TrimPrefix(string(key), "sha:"),
+				Timestamp: committedDate,
+				Coverage:  coverage,
+			})
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(history, func(i, j int) bool { return history[i].Timestamp.Before(history[j].Timestamp) })
+
+	return history, nil
+}
+
+func (s *boltStore) PutJobCoverage(ctx context.Context, projectID int, sha string, job JobCoverage) error {
+	return s.storeCommitData(projectID, sha, func(commitBucket *bolt.Bucket) error {
+		jobsBucket, err := commitBucket.CreateBucketIfNotExists([]byte("jobs"))
+		if err != nil {
+			return fmt.Errorf("create jobs bucket error: %s", err)
+		}
+
+		data, err := json.Marshal(job)
+		if err != nil {
+			return fmt.Errorf("marshal job coverage error: %s", err)
+		}
+
+		if err := jobsBucket.Put([]byte(strconv.Itoa(job.JobID)), data); err != nil {
+			return fmt.Errorf("store job coverage error: %s", err)
+		}
+
+		return nil
+	})
+}
+
+func (s *boltStore) GetJobCoverages(ctx context.Context, projectID int, sha string) ([]JobCoverage, error) {
+	var jobs []JobCoverage
+
+	err := s.readFromCommitBucket(projectID, sha, func(commitBucket *bolt.Bucket) error {
+		jobsBucket := commitBucket.Bucket([]byte("jobs"))
+		if jobsBucket == nil {
+			return nil
+		}
+
+		return jobsBucket.ForEach(func(_, data []byte) error {
+			var job JobCoverage
+			if err := json.Unmarshal(data, &job); err != nil {
+				return fmt.Errorf("unmarshal job coverage error: %s", err)
+			}
+			jobs = append(jobs, job)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
+
+	return jobs, nil
+}
+
+func (s *boltStore) PutFileCoverage(ctx context.Context, projectID int, sha string, hits fileLineHits) error {
+	if len(hits) == 0 {
+		return nil
+	}
+
+	return s.storeCommitData(projectID, sha, func(commitBucket *bolt.Bucket) error {
+		filesBucket, err := commitBucket.CreateBucketIfNotExists([]byte("files"))
+		if err != nil {
+			return fmt.Errorf("create files bucket error: %s", err)
+		}
+
+		for path, lines := range hits {
+			existing := FileCoverage{Lines: map[int]int{}}
+			if data := filesBucket.Get([]byte(path)); data != nil {
+				if err := json.Unmarshal(data, &existing); err != nil {
+					return fmt.Errorf("unmarshal file coverage error: %s", err)
+				}
+			}
+
+			for line, fileHits := range lines {
+				if fileHits > existing.Lines[line] {
+					existing.Lines[line] = fileHits
+				}
+			}
+
+			data, err := json.Marshal(existing)
+			if err != nil {
+				return fmt.Errorf("marshal file coverage error: %s", err)
+			}
+
+			if err := filesBucket.Put([]byte(path), data); err != nil {
+				return fmt.Errorf("store file coverage error: %s", err)
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *boltStore) GetFileCoverage(ctx context.Context, projectID int, sha, path string) (*FileCoverage, error) {
+	var coverage *FileCoverage
+
+	err := s.readFromCommitBucket(projectID, sha, func(commitBucket *bolt.Bucket) error {
+		filesBucket := commitBucket.Bucket([]byte("files"))
+		if filesBucket == nil {
+			return nil
+		}
+
+		data := filesBucket.Get([]byte(path))
+		if data == nil {
+			return nil
+		}
+
+		var fc FileCoverage
+		if err := json.Unmarshal(data, &fc); err != nil {
+			return fmt.Errorf("unmarshal file coverage error: %s", err)
+		}
+
+		coverage = &fc
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return coverage, nil
+}
+
+func (s *boltStore) LinkMR(ctx context.Context, projectID, mergeRequestID int, sha string) error {
+	return s.storeCommitData(projectID, sha, func(commitBucket *bolt.Bucket) error {
+		mergeRequestIDsBucket, err := commitBucket.CreateBucketIfNotExists([]byte("mrs"))
+		if err != nil {
+			return fmt.Errorf("create merge request IDs bucket error: %s", err)
+		}
+
+		// Only keys matter here, so put zero byte as value.
+		if err := mergeRequestIDsBucket.Put([]byte(strconv.Itoa(mergeRequestID)), []byte("\x00")); err != nil {
+			return fmt.Errorf("store merge request ID into commit error: %s", err)
+		}
+
+		return nil
+	})
+}
+
+func (s *boltStore) GetLinkedMRs(ctx context.Context, projectID int, sha string) ([]int, error) {
+	var mergeRequestIDs []int
+
+	err := s.readFromCommitBucket(projectID, sha, func(commitBucket *bolt.Bucket) error {
+		linkedMergeRequestsBucket := commitBucket.Bucket([]byte("mrs"))
+		if linkedMergeRequestsBucket == nil {
+			return nil
+		}
+
+		return linkedMergeRequestsBucket.ForEach(func(mergeRequestIDStr, _ []byte) error {
+			mergeRequestID, _ := strconv.Atoi(string(mergeRequestIDStr))
+			mergeRequestIDs = append(mergeRequestIDs, mergeRequestID)
+			return nil
+		})
+	})
+
+	return mergeRequestIDs, err
+}
+
+func (s *boltStore) PutMRData(ctx context.Context, projectID, mergeRequestID int, beforeSHA, lastCommitSHA, targetBranch string) error {
+	return s.storeInMergeRequestBucket(projectID, mergeRequestID, func(mergeRequestBucket *bolt.Bucket) error {
+		if err := mergeRequestBucket.Put([]byte("beforeSHA"), []byte(beforeSHA)); err != nil {
+			return fmt.Errorf("storing before commit SHA: %s", err)
+		}
+
+		if err := mergeRequestBucket.Put([]byte("lastCommitSHA"), []byte(lastCommitSHA)); err != nil {
+			return fmt.Errorf("storing last commit SHA: %s", err)
+		}
+
+		if err := mergeRequestBucket.Put([]byte("targetBranch"), []byte(targetBranch)); err != nil {
+			return fmt.Errorf("storing target branch: %s", err)
+		}
+
+		return nil
+	})
+}
+
+func (s *boltStore) GetMRCommits(ctx context.Context, projectID, mergeRequestID int) (beforeSHA, lastCommitSHA, targetBranch string, err error) {
+	err = s.readFromMergeRequestBucket(projectID, mergeRequestID, func(mergeRequestBucket *bolt.Bucket) error {
+		if sha := mergeRequestBucket.Get([]byte("beforeSHA")); sha != nil {
+			beforeSHA = string(sha)
+		}
+
+		if sha := mergeRequestBucket.Get([]byte("lastCommitSHA")); sha != nil {
+			lastCommitSHA = string(sha)
+		}
+
+		if branch := mergeRequestBucket.Get([]byte("targetBranch")); branch != nil {
+			targetBranch = string(branch)
+		}
+
+		return nil
+	})
+
+	return
+}
+
+func (s *boltStore) PutNoteID(ctx context.Context, projectID, mergeRequestID, noteID int) error {
+	return s.storeInMergeRequestBucket(projectID, mergeRequestID, func(mergeRequestBucket *bolt.Bucket) error {
+		if err := mergeRequestBucket.Put([]byte("note_id"), []byte(strconv.Itoa(noteID))); err != nil {
+			return fmt.Errorf("storing note ID error: %s", err)
+		}
+
+		return nil
+	})
+}
+
+func (s *boltStore) GetNoteID(ctx context.Context, projectID, mergeRequestID int) (int, error) {
+	var noteID int
+
+	err := s.readFromMergeRequestBucket(projectID, mergeRequestID, func(mergeRequestBucket *bolt.Bucket) error {
+		noteIDBytes := mergeRequestBucket.Get([]byte("note_id"))
+		if noteIDBytes == nil {
+			return nil
+		}
+
+		var err error
+		noteID, err = strconv.Atoi(string(noteIDBytes))
+		return err
+	})
+
+	return noteID, err
+}
+
+func (s *boltStore) IsDiscussionPosted(ctx context.Context, projectID, mergeRequestID int, path string, line int) (bool, error) {
+	var posted bool
+
+	err := s.readFromMergeRequestBucket(projectID, mergeRequestID, func(mergeRequestBucket *bolt.Bucket) error {
+		discussionsBucket := mergeRequestBucket.Bucket([]byte("discussions"))
+		if discussionsBucket == nil {
+			return nil
+		}
+
+		posted = discussionsBucket.Get(discussionKey(path, line)) != nil
+		return nil
+	})
+
+	return posted, err
+}
+
+func (s *boltStore) MarkDiscussionPosted(ctx context.Context, projectID, mergeRequestID int, path string, line int) error {
+	return s.storeInMergeRequestBucket(projectID, mergeRequestID, func(mergeRequestBucket *bolt.Bucket) error {
+		discussionsBucket, err := mergeRequestBucket.CreateBucketIfNotExists([]byte("discussions"))
+		if err != nil {
+			return fmt.Errorf("create discussions bucket error: %s", err)
+		}
+
+		// Only keys matter here, so put zero byte as value.
+		if err := discussionsBucket.Put(discussionKey(path, line), []byte("\x00")); err != nil {
+			return fmt.Errorf("store discussion marker error: %s", err)
+		}
+
+		return nil
+	})
+}
+
+func (s *boltStore) storeCommitData(projectID int, sha string, storeFn func(*bolt.Bucket) error) error {
+	defer observeStoreTx("bolt", "store_commit_data", time.Now())
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		projectBucket, err := tx.CreateBucketIfNotExists([]byte(fmt.Sprintf("projects:%d", projectID)))
+		if err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+
+		commitBucket, err := projectBucket.CreateBucketIfNotExists([]byte(fmt.Sprintf("sha:%s", sha)))
+		if err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+
+		return storeFn(commitBucket)
+	})
+}
+
+func (s *boltStore) readFromCommitBucket(projectID int, sha string, readFn func(*bolt.Bucket) error) error {
+	defer observeStoreTx("bolt", "read_commit_bucket", time.Now())
+
+	return s.db.View(func(tx *bolt.Tx) error {
+		projectBucket := tx.Bucket([]byte(fmt.Sprintf("projects:%d", projectID)))
+		if projectBucket == nil {
+			return nil
+		}
+
+		commitBucket := projectBucket.Bucket([]byte(fmt.Sprintf("sha:%s", sha)))
+		if commitBucket == nil {
+			return nil
+		}
+
+		return readFn(commitBucket)
+	})
+}
+
+func (s *boltStore) storeInMergeRequestBucket(projectID, mergeRequestID int, storeFn func(*bolt.Bucket) error) error {
+	defer observeStoreTx("bolt", "store_mr_bucket", time.Now())
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		projectBucket, err := tx.CreateBucketIfNotExists([]byte(fmt.Sprintf("projects:%d", projectID)))
+		if err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+
+		mergeRequestBucket, err := projectBucket.
+			CreateBucketIfNotExists([]byte(fmt.Sprintf("mr:%d", mergeRequestID)))
+		if err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+
+		return storeFn(mergeRequestBucket)
+	})
+}
+
+func (s *boltStore) readFromMergeRequestBucket(projectID, mergeRequestID int, readFn func(*bolt.Bucket) error) error {
+	defer observeStoreTx("bolt", "read_mr_bucket", time.Now())
+
+	return s.db.View(func(tx *bolt.Tx) error {
+		projectBucket := tx.Bucket([]byte(fmt.Sprintf("projects:%d", projectID)))
+		if projectBucket == nil {
+			return nil
+		}
+
+		mergeRequestBucket := projectBucket.Bucket([]byte(fmt.Sprintf("mr:%d", mergeRequestID)))
+		if mergeRequestBucket == nil {
+			return nil
+		}
+
+		return readFn(mergeRequestBucket)
+	})
+}
+
+// discussionKey is the bucket key a posted discussion marker is stored
+// under, so the same (path, line) always maps to the same key.
+func discussionKey(path string, line int) []byte {
+	return []byte(fmt.Sprintf("%s:%d", path, line))
+}
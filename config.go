@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+const defaultConfigPath = "config.yaml"
+
+// ProjectConfig holds per-project overrides for settings that otherwise
+// fall back to globally configured defaults (env vars).
+type ProjectConfig struct {
+	WebhookSecret     string            `yaml:"webhook_secret"`
+	Policy            *CoveragePolicy   `yaml:"policy"`
+	AggregationMethod AggregationMethod `yaml:"aggregation_method"`
+}
+
+// Config is the root of config.yaml: project-scoped overrides keyed by
+// GitLab project ID.
+type Config struct {
+	Projects map[int]ProjectConfig `yaml:"projects"`
+}
+
+// projectConfig returns the override for projectID, or nil if none is set.
+func (c *Config) projectConfig(projectID int) *ProjectConfig {
+	if c == nil {
+		return nil
+	}
+
+	if projectCfg, ok := c.Projects[projectID]; ok {
+		return &projectCfg
+	}
+
+	return nil
+}
+
+// loadConfig reads and parses config.yaml from path. A missing file is not
+// an error: it simply means no per-project overrides are configured.
+func loadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Config{}, nil
+		}
+
+		return nil, err
+	}
+
+	var config Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// AggregationMethod controls how the coverage numbers reported by a
+// commit's individual CI jobs are combined into a single commit-level
+// coverage percentage.
+type AggregationMethod string
+
+const (
+	AggregationMean         AggregationMethod = "mean"
+	AggregationMax          AggregationMethod = "max"
+	AggregationWeightedMean AggregationMethod = "weighted_mean"
+)
+
+const defaultAggregationMethod = AggregationMean
+
+// JobCoverage is the coverage reported by a single CI job for a commit.
+type JobCoverage struct {
+	JobID     int       `json:"job_id"`
+	Name      string    `json:"name"`
+	Coverage  float64   `json:"coverage"`
+	LineCount int       `json:"line_count"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// CoverageHistoryEntry is a single point in a ref's coverage trend: the
+// finalized coverage of one of its commits, in the order it was committed.
+type CoverageHistoryEntry struct {
+	SHA       string    `json:"sha"`
+	Timestamp time.Time `json:"timestamp"`
+	Coverage  float64   `json:"coverage"`
+}
+
+// aggregationMethodForProject resolves the configured aggregation method
+// for projectID, falling back to the arithmetic mean.
+func aggregationMethodForProject(projectID int) AggregationMethod {
+	if projectCfg := config.projectConfig(projectID); projectCfg != nil && len(projectCfg.AggregationMethod) > 0 {
+		return projectCfg.AggregationMethod
+	}
+
+	return defaultAggregationMethod
+}
+
+// aggregateCoverage combines per-job coverage numbers according to method.
+func aggregateCoverage(jobs []JobCoverage, method AggregationMethod) float64 {
+	if len(jobs) == 0 {
+		return 0
+	}
+
+	switch method {
+	case AggregationMax:
+		max := jobs[0].Coverage
+		for _, job := range jobs[1:] {
+			if job.Coverage > max {
+				max = job.Coverage
+			}
+		}
+		return max
+	case AggregationWeightedMean:
+		var totalLines int
+		var weightedSum float64
+		for _, job := range jobs {
+			weightedSum += job.Coverage * float64(job.LineCount)
+			totalLines += job.LineCount
+		}
+		if totalLines == 0 {
+			return aggregateCoverage(jobs, AggregationMean)
+		}
+		return weightedSum / float64(totalLines)
+	default:
+		var sum float64
+		for _, job := range jobs {
+			sum += job.Coverage
+		}
+		return sum / float64(len(jobs))
+	}
+}
+
+// finalizeCommitCoverage aggregates all per-job coverage recorded for sha
+// and stores the result under the commit's coverage, so it keeps being
+// readable through store.GetCoverage.
+func finalizeCommitCoverage(ctx context.Context, projectID int, sha string) (float64, error) {
+	jobs, err := store.GetJobCoverages(ctx, projectID, sha)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(jobs) == 0 {
+		return 0, nil
+	}
+
+	coverage := aggregateCoverage(jobs, aggregationMethodForProject(projectID))
+
+	if err := store.PutCoverage(ctx, projectID, sha, coverage); err != nil {
+		return 0, err
+	}
+
+	return coverage, nil
+}
+
+// jobsCoverageTable renders a Markdown table with the per-job coverage
+// breakdown, appended to the MR note alongside the aggregate summary.
+func jobsCoverageTable(jobs []JobCoverage) string {
+	if len(jobs) == 0 {
+		return ""
+	}
+
+	table := "\n\n| Job | Coverage | Lines |\n| --- | --- | --- |\n"
+	for _, job := range jobs {
+		table += fmt.Sprintf("| %s | %s%% | %d |\n",
+			job.Name, strconv.FormatFloat(job.Coverage, 'f', -1, 64), job.LineCount)
+	}
+
+	return table
+}
@@ -0,0 +1,74 @@
+package main
+
+import "testing"
+
+func TestAggregateCoverage(t *testing.T) {
+	tests := []struct {
+		name   string
+		jobs   []JobCoverage
+		method AggregationMethod
+		want   float64
+	}{
+		{
+			name:   "no jobs",
+			jobs:   nil,
+			method: AggregationMean,
+			want:   0,
+		},
+		{
+			name: "mean",
+			jobs: []JobCoverage{
+				{Coverage: 50},
+				{Coverage: 100},
+			},
+			method: AggregationMean,
+			want:   75,
+		},
+		{
+			name: "unknown method falls back to mean",
+			jobs: []JobCoverage{
+				{Coverage: 50},
+				{Coverage: 100},
+			},
+			method: AggregationMethod("bogus"),
+			want:   75,
+		},
+		{
+			name: "max",
+			jobs: []JobCoverage{
+				{Coverage: 50},
+				{Coverage: 90},
+				{Coverage: 10},
+			},
+			method: AggregationMax,
+			want:   90,
+		},
+		{
+			name: "weighted mean",
+			jobs: []JobCoverage{
+				{Coverage: 100, LineCount: 10},
+				{Coverage: 0, LineCount: 90},
+			},
+			method: AggregationWeightedMean,
+			want:   10,
+		},
+		{
+			name: "weighted mean falls back to mean when no lines reported",
+			jobs: []JobCoverage{
+				{Coverage: 40, LineCount: 0},
+				{Coverage: 60, LineCount: 0},
+			},
+			method: AggregationWeightedMean,
+			want:   50,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := aggregateCoverage(tt.jobs, tt.method)
+			if got != tt.want {
+				t.Errorf("aggregateCoverage() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
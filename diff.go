@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+)
+
+// addedLines returns the new-file line numbers introduced by a unified diff
+// hunk body, as returned in the "diff" field of the GitLab merge request
+// changes API (i.e. without the usual "--- a/..."/"+++ b/..." file headers).
+func addedLines(diff string) []int {
+	var lines []int
+	newLine := 0
+
+	scanner := bufio.NewScanner(strings.NewReader(diff))
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch {
+		case strings.HasPrefix(line, "@@"):
+			newLine = hunkNewLineStart(line)
+		case strings.HasPrefix(line, "+"):
+			lines = append(lines, newLine)
+			newLine++
+		case strings.HasPrefix(line, "-"):
+			// Only present in the old file, doesn't advance the new line count.
+		default:
+			newLine++
+		}
+	}
+
+	return lines
+}
+
+// hunkNewLineStart extracts the starting new-file line number from a hunk
+// header of the form "@@ -oldStart,oldCount +newStart,newCount @@".
+func hunkNewLineStart(header string) int {
+	for _, field := range strings.Fields(header) {
+		if !strings.HasPrefix(field, "+") {
+			continue
+		}
+
+		newRange := strings.TrimPrefix(field, "+")
+		start, _, _ := strings.Cut(newRange, ",")
+
+		n, err := strconv.Atoi(start)
+		if err != nil {
+			return 0
+		}
+
+		return n
+	}
+
+	return 0
+}
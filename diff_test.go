@@ -0,0 +1,80 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHunkNewLineStart(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{
+			name:   "single line range",
+			header: "@@ -10,7 +12,9 @@ func foo() {",
+			want:   12,
+		},
+		{
+			name:   "no count suffix",
+			header: "@@ -1 +1 @@",
+			want:   1,
+		},
+		{
+			name:   "malformed header",
+			header: "@@ garbage @@",
+			want:   0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hunkNewLineStart(tt.header); got != tt.want {
+				t.Errorf("hunkNewLineStart() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddedLines(t *testing.T) {
+	tests := []struct {
+		name string
+		diff string
+		want []int
+	}{
+		{
+			name: "single hunk with additions and deletions",
+			diff: "@@ -1,3 +1,4 @@\n" +
+				" unchanged\n" +
+				"-removed\n" +
+				"+added one\n" +
+				"+added two\n" +
+				" unchanged\n",
+			want: []int{2, 3},
+		},
+		{
+			name: "multiple hunks",
+			diff: "@@ -1,2 +1,3 @@\n" +
+				" unchanged\n" +
+				"+added\n" +
+				"@@ -10,2 +11,3 @@\n" +
+				" unchanged\n" +
+				"+added\n",
+			want: []int{2, 12},
+		},
+		{
+			name: "no additions",
+			diff: "@@ -1,2 +1,2 @@\n unchanged\n unchanged\n",
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := addedLines(tt.diff); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("addedLines() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/xanzy/go-gitlab"
+)
+
+// postUncoveredLineDiscussions posts an inline discussion on every line the
+// merge request newly introduces that no job's coverage report covered.
+// Lines already discussed in a previous run are skipped.
+func postUncoveredLineDiscussions(ctx context.Context, projectID, mergeRequestID int, sha string) {
+	log := zerolog.Ctx(ctx)
+
+	start := time.Now()
+	mr, _, err := git.MergeRequests.GetMergeRequestChanges(projectID, mergeRequestID)
+	observeGitlabAPICall("GetMergeRequestChanges", start, err)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch merge request changes")
+		return
+	}
+
+	for _, change := range mr.Changes {
+		if change.DeletedFile {
+			continue
+		}
+
+		fileCoverage, err := store.GetFileCoverage(ctx, projectID, sha, normalizeCoveragePath(change.NewPath))
+		if err != nil {
+			log.Error().Err(err).Str("path", change.NewPath).Msg("Failed to get file coverage")
+			continue
+		}
+		if fileCoverage == nil {
+			continue
+		}
+
+		uncovered := fileCoverage.uncoveredLines()
+		if len(uncovered) == 0 {
+			continue
+		}
+
+		for _, line := range addedLines(change.Diff) {
+			if !uncovered[line] {
+				continue
+			}
+
+			alreadyPosted, err := store.IsDiscussionPosted(ctx, projectID, mergeRequestID, change.NewPath, line)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to check discussion state")
+				continue
+			}
+			if alreadyPosted {
+				continue
+			}
+
+			if err := postUncoveredLineDiscussion(ctx, projectID, mergeRequestID, mr, change.NewPath, line); err != nil {
+				log.Error().Err(err).Str("path", change.NewPath).Int("line", line).Msg("Failed to post uncovered line discussion")
+				continue
+			}
+
+			if err := store.MarkDiscussionPosted(ctx, projectID, mergeRequestID, change.NewPath, line); err != nil {
+				log.Error().Err(err).Msg("Failed to record posted discussion")
+			}
+		}
+	}
+}
+
+func postUncoveredLineDiscussion(ctx context.Context, projectID, mergeRequestID int, mr *gitlab.MergeRequest, path string, line int) error {
+	opts := &gitlab.CreateMergeRequestDiscussionOptions{
+		Body: gitlab.String(fmt.Sprintf("Line %d is not covered by any test.", line)),
+		Position: &gitlab.NotePosition{
+			BaseSHA:      mr.DiffRefs.BaseSha,
+			StartSHA:     mr.DiffRefs.StartSha,
+			HeadSHA:      mr.DiffRefs.HeadSha,
+			PositionType: "text",
+			NewPath:      path,
+			NewLine:      line,
+		},
+	}
+
+	start := time.Now()
+	_, _, err := git.Discussions.CreateMergeRequestDiscussion(projectID, mergeRequestID, opts)
+	observeGitlabAPICall("CreateMergeRequestDiscussion", start, err)
+
+	return err
+}
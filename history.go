@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// registerCoverageHistoryHandlers wires up the read-only coverage history
+// and badge endpoints, so there's a way to look at coverage trends beyond
+// the one-off MR note.
+func registerCoverageHistoryHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/projects/", coverageAPIHandler)
+}
+
+// coverageAPIHandler routes GET /projects/{id}/coverage/history and
+// GET /projects/{id}/coverage/badge.svg.
+func coverageAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(segments) != 4 || segments[0] != "projects" || segments[2] != "coverage" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	projectID, err := strconv.Atoi(segments[1])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	switch segments[3] {
+	case "history":
+		coverageHistoryHandler(w, r, projectID)
+	case "badge.svg":
+		coverageBadgeHandler(w, r, projectID)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// coverageHistoryHandler returns the JSON time series of
+// {sha, timestamp, coverage} for ref, defaulting to the project's default
+// branch and to the full history kept in the store.
+func coverageHistoryHandler(w http.ResponseWriter, r *http.Request, projectID int) {
+	ref, err := refOrDefaultBranch(projectID, r.URL.Query().Get("ref"))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to resolve ref for coverage history")
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	since, err := parseSince(r.URL.Query().Get("since"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	history, err := store.GetCoverageHistory(r.Context(), projectID, ref, since)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get coverage history")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(history); err != nil {
+		log.Error().Err(err).Msg("Failed to encode coverage history")
+	}
+}
+
+// coverageBadgeHandler renders a shields.io-style SVG badge for the latest
+// coverage recorded on ref, defaulting to the project's default branch.
+func coverageBadgeHandler(w http.ResponseWriter, r *http.Request, projectID int) {
+	ref, err := refOrDefaultBranch(projectID, r.URL.Query().Get("ref"))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to resolve ref for coverage badge")
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	history, err := store.GetCoverageHistory(r.Context(), projectID, ref, time.Time{})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get coverage history")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var coverage float64
+	if len(history) > 0 {
+		coverage = history[len(history)-1].Coverage
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprint(w, coverageBadgeSVG(coverage))
+}
+
+// refOrDefaultBranch returns ref unchanged if set, otherwise resolves
+// projectID's default branch through the GitLab API.
+func refOrDefaultBranch(projectID int, ref string) (string, error) {
+	if len(ref) > 0 {
+		return ref, nil
+	}
+
+	start := time.Now()
+	project, _, err := git.Projects.GetProject(projectID, nil)
+	observeGitlabAPICall("GetProject", start, err)
+	if err != nil {
+		return "", fmt.Errorf("fetch project: %s", err)
+	}
+
+	return project.DefaultBranch, nil
+}
+
+func parseSince(value string) (time.Time, error) {
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+const badgeSVGTemplate = `<svg xmlns="http://www.w3.org/2000/svg" width="114" height="20" role="img" aria-label="coverage: %s">
+  <linearGradient id="s" x2="0" y2="100%%">
+    <stop offset="0" stop-color="#bbb" stop-opacity=".1"/>
+    <stop offset="1" stop-opacity=".1"/>
+  </linearGradient>
+  <clipPath id="r">
+    <rect width="114" height="20" rx="3" fill="#fff"/>
+  </clipPath>
+  <g clip-path="url(#r)">
+    <rect width="61" height="20" fill="#555"/>
+    <rect x="61" width="53" height="20" fill="%s"/>
+    <rect width="114" height="20" fill="url(#s)"/>
+  </g>
+  <g fill="#fff" text-anchor="middle" font-family="DejaVu Sans,Verdana,Geneva,sans-serif" text-rendering="geometricPrecision" font-size="11">
+    <text x="31" y="14">coverage</text>
+    <text x="87" y="14">%s</text>
+  </g>
+</svg>
+`
+
+// coverageBadgeSVG renders a flat, shields.io-style badge for coverage,
+// colored red/yellow/green the same way shields.io's own coverage badges are.
+func coverageBadgeSVG(coverage float64) string {
+	label := fmt.Sprintf("%s%%", strconv.FormatFloat(coverage, 'f', -1, 64))
+	if coverage == 0 {
+		label = "unknown"
+	}
+
+	return fmt.Sprintf(badgeSVGTemplate, label, badgeColor(coverage), label)
+}
+
+// badgeColor mirrors shields.io's default coverage color thresholds.
+func badgeColor(coverage float64) string {
+	switch {
+	case coverage == 0:
+		return "#9f9f9f"
+	case coverage < 50:
+		return "#e05d44"
+	case coverage < 80:
+		return "#dfb317"
+	default:
+		return "#4c1"
+	}
+}
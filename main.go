@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
 	"fmt"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
 	"github.com/xanzy/go-gitlab"
-	"go.etcd.io/bbolt"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"io/ioutil"
 	"net/http"
 	"os"
@@ -13,8 +18,11 @@ import (
 	"time"
 )
 
-var db *bolt.DB
+var store Store
 var git *gitlab.Client
+var config *Config
+var webhookSecret string
+var shutdownTracing func(context.Context) error
 
 func main() {
 	zerolog.TimeFieldFormat = ""
@@ -22,17 +30,80 @@ func main() {
 
 	log.Info().Msg("Gitlab Merge Request Coverage reporter")
 
-	port := getRequiredEnvVar("PORT")
+	app := &cli.App{
+		Name:   "gitlab-review-coverage",
+		Usage:  "GitLab Merge Request Coverage reporter",
+		Before: setupGlobals,
+		After:  teardownGlobals,
+		Action: runServe,
+		Commands: []*cli.Command{
+			backfillCommand(),
+			reconcileMRCommand(),
+		},
+	}
+
+	if err := app.Run(os.Args); err != nil {
+		log.Fatal().Err(err).Msg("Command failed")
+	}
+}
+
+// setupGlobals prepares the config, tracing, store, and GitLab client shared
+// by every subcommand, so backfill/reconcile-mr can reuse the exact same
+// coverage-processing code paths as the live webhook listener.
+func setupGlobals(c *cli.Context) error {
+	ctx := context.Background()
+
 	gitlabBaseURL := getRequiredEnvVar("GITLAB_BASE_URL")
 	gitlabToken := getRequiredEnvVar("GITLAB_TOKEN")
-	boltDBPath := getRequiredEnvVar("BOLT_DB_PATH")
+	configPath := getEnvVarOrDefault("CONFIG_PATH", defaultConfigPath)
 
 	log.Info().Msgf("Working with GitLab: %s", gitlabBaseURL)
 
-	db = prepareDatabase(boltDBPath)
+	var err error
+	config, err = loadConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("load config file %s: %s", configPath, err)
+	}
+
+	shutdownTracing, err = initTracing(ctx)
+	if err != nil {
+		return fmt.Errorf("initialize tracing: %s", err)
+	}
+
+	store, err = newStore()
+	if err != nil {
+		return fmt.Errorf("initialize store: %s", err)
+	}
+
 	git = prepareGitlabClient(gitlabBaseURL, gitlabToken)
 
+	return nil
+}
+
+// teardownGlobals releases what setupGlobals acquired, once the selected
+// command has finished running.
+func teardownGlobals(c *cli.Context) error {
+	if store != nil {
+		if err := store.Close(); err != nil {
+			log.Error().Err(err).Msg("Failed to close store")
+		}
+	}
+
+	if shutdownTracing != nil {
+		shutdownTracing(context.Background())
+	}
+
+	return nil
+}
+
+// runServe is the default command: start the webhook listener.
+func runServe(c *cli.Context) error {
+	port := getRequiredEnvVar("PORT")
+	webhookSecret = getRequiredEnvVar("GITLAB_WEBHOOK_SECRET")
+
 	startWebhookListener(port)
+
+	return nil
 }
 
 func getRequiredEnvVar(varName string) string {
@@ -43,30 +114,29 @@ func getRequiredEnvVar(varName string) string {
 	return envVar
 }
 
+func getEnvVarOrDefault(varName, defaultValue string) string {
+	envVar := os.Getenv(varName)
+	if len(envVar) == 0 {
+		return defaultValue
+	}
+	return envVar
+}
+
 func startWebhookListener(port string) {
-	http.HandleFunc("/", webhookHandler)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", webhookHandler)
+	registerMetricsHandler(mux)
+	registerCoverageHistoryHandlers(mux)
 
 	log.Info().Msgf("Starting webhook listener on port %s", port)
 
-	err := http.ListenAndServe(fmt.Sprintf(":%s", port), nil)
+	err := http.ListenAndServe(fmt.Sprintf(":%s", port), mux)
 	if err != nil {
 		log.Fatal().Err(err).Msgf("Failed to start webhook listener")
 	}
 
 }
 
-func prepareDatabase(boltDataPath string) *bolt.DB {
-	db, err := bolt.Open(boltDataPath, 0600, &bolt.Options{Timeout: 1 * time.Second})
-
-	if err != nil {
-		log.Fatal().Err(err).Msgf("Failed to open BoltDB file %s", boltDataPath)
-	}
-
-	log.Info().Msgf("BoltDB data file path: %s", db.Path())
-
-	return db
-}
-
 func prepareGitlabClient(gitlabBaseURL, gitlabToken string) *gitlab.Client {
 	git := gitlab.NewClient(nil, gitlabToken)
 
@@ -79,6 +149,9 @@ func prepareGitlabClient(gitlabBaseURL, gitlabToken string) *gitlab.Client {
 }
 
 func webhookHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "webhookHandler")
+	defer span.End()
+
 	log.Debug().Msg("Received webhook request")
 	payload, err := ioutil.ReadAll(r.Body)
 
@@ -87,6 +160,14 @@ func webhookHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !isWebhookTokenValid(r, payload) {
+		log.Warn().
+			Str("remote_addr", r.RemoteAddr).
+			Msg("Rejecting webhook request with invalid X-Gitlab-Token")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
 	event, err := gitlab.ParseWebhook(gitlab.WebhookEventType(r), payload)
 
 	if err != nil {
@@ -96,61 +177,125 @@ func webhookHandler(w http.ResponseWriter, r *http.Request) {
 
 	switch event := event.(type) {
 	case *gitlab.MergeEvent:
-		handleMergeRequestEvent(event)
+		webhookEventsTotal.WithLabelValues("merge_request").Inc()
+		handleMergeRequestEvent(ctx, event)
 	case *gitlab.BuildEvent:
-		handleBuildEvent(event)
+		webhookEventsTotal.WithLabelValues("build").Inc()
+		handleBuildEvent(ctx, event)
+	case *gitlab.PipelineEvent:
+		webhookEventsTotal.WithLabelValues("pipeline").Inc()
+		handlePipelineEvent(ctx, event)
 	default:
+		webhookEventsTotal.WithLabelValues("unknown").Inc()
 		log.Debug().Msg("Skipping event type")
 	}
 }
 
-func handleMergeRequestEvent(event *gitlab.MergeEvent) {
+// isWebhookTokenValid checks the X-Gitlab-Token header against the secret
+// configured for the event's project, falling back to the global
+// GITLAB_WEBHOOK_SECRET when no per-project override is set.
+func isWebhookTokenValid(r *http.Request, payload []byte) bool {
+	expectedSecret := webhookSecret
+
+	if projectCfg := config.projectConfig(webhookProjectID(payload)); projectCfg != nil && len(projectCfg.WebhookSecret) > 0 {
+		expectedSecret = projectCfg.WebhookSecret
+	}
+
+	token := r.Header.Get("X-Gitlab-Token")
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(expectedSecret)) == 1
+}
+
+// webhookProjectID extracts the project ID from a raw webhook payload
+// without fully parsing it, so the secret check can happen before the
+// event is decoded into its concrete type.
+func webhookProjectID(payload []byte) int {
+	var partial struct {
+		ProjectID int `json:"project_id"`
+		Project   struct {
+			ID int `json:"id"`
+		} `json:"project"`
+	}
+
+	if err := json.Unmarshal(payload, &partial); err != nil {
+		return 0
+	}
+
+	if partial.ProjectID != 0 {
+		return partial.ProjectID
+	}
+
+	return partial.Project.ID
+}
+
+// detachedContext carries the trace span of parentCtx onto a fresh,
+// non-cancelable context so background goroutines keep their place in the
+// trace after the originating HTTP request has returned.
+func detachedContext(parentCtx context.Context, reqLog zerolog.Logger) context.Context {
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(parentCtx))
+	return reqLog.WithContext(ctx)
+}
+
+func handleMergeRequestEvent(ctx context.Context, event *gitlab.MergeEvent) {
 	projectID := event.ObjectAttributes.TargetProjectID
 	mergeRequestID := event.ObjectAttributes.IID
 	lastCommitSHA := event.ObjectAttributes.LastCommit.ID
 
-	log := log.With().
+	reqLog := log.With().
 		Int("project_id", projectID).
 		Int("merge_request_id", mergeRequestID).
 		Str("sha", lastCommitSHA).
 		Logger()
 
-	log.Debug().
+	reqLog.Debug().
 		Interface("event", event).
 		Msg("Merge request event received")
 
-	go processMergeRequest(projectID, mergeRequestID, &log)
+	ctx = detachedContext(ctx, reqLog)
+
+	go processMergeRequest(ctx, projectID, mergeRequestID)
 }
 
-func handleBuildEvent(event *gitlab.BuildEvent) {
+func handleBuildEvent(ctx context.Context, event *gitlab.BuildEvent) {
 	projectID := event.ProjectID
 	jobID := event.BuildID
-	sha := event.Sha
+	sha := event.SHA
 
-	log := log.With().
+	reqLog := log.With().
 		Int("project_id", projectID).
 		Int("job", jobID).
 		Str("sha", sha).
 		Logger()
 
-	log.Debug().
+	reqLog.Debug().
 		Interface("event", event).
 		Msg("Build event received")
 
 	if event.BuildStatus != "success" {
-		log.Debug().
+		reqLog.Debug().
 			Str("status", event.BuildStatus).
 			Msg("Skipping as status is not success")
 
 		return
 	}
 
-	go handleCommitCoverage(projectID, event, &log)
+	ctx = detachedContext(ctx, reqLog)
+
+	go handleCommitCoverage(ctx, projectID, event)
 }
 
-func handleCommitCoverage(projectID int, event *gitlab.BuildEvent, log *zerolog.Logger) {
+func handleCommitCoverage(ctx context.Context, projectID int, event *gitlab.BuildEvent) {
+	ctx, span := tracer.Start(ctx, "handleCommitCoverage")
+	defer span.End()
+
+	log := zerolog.Ctx(ctx)
+
+	start := time.Now()
 	job, _, err := git.Jobs.GetJob(projectID, event.BuildID)
+	observeGitlabAPICall("GetJob", start, err)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		log.Error().Err(err).Msg("Failed to fetch job")
 		return
 	}
@@ -162,34 +307,123 @@ func handleCommitCoverage(projectID int, event *gitlab.BuildEvent, log *zerolog.
 		return
 	}
 
-	err = storeCommitCoverage(projectID, event.Sha, coverage)
+	jobCoverage := JobCoverage{
+		JobID:     event.BuildID,
+		Name:      job.Name,
+		Coverage:  coverage,
+		Timestamp: time.Now(),
+	}
+
+	fileHits, err := fetchJobArtifactCoverage(ctx, projectID, event.BuildID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch job artifact coverage")
+	} else if len(fileHits) > 0 {
+		jobCoverage.LineCount = fileHits.lineCount()
+
+		if err := store.PutFileCoverage(ctx, projectID, event.SHA, fileHits); err != nil {
+			log.Error().Err(err).Msg("Failed to store file coverage")
+		}
+	}
+
+	err = store.PutJobCoverage(ctx, projectID, event.SHA, jobCoverage)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to store job coverage")
+		return
+	}
+
+	log.Info().Float64("coverage", coverage).Msg("Job coverage is stored")
+}
+
+// handlePipelineEvent finalizes a commit's coverage once its pipeline has
+// finished, by aggregating every job coverage report collected along the
+// way and then notifying any merge requests linked to the commit.
+func handlePipelineEvent(ctx context.Context, event *gitlab.PipelineEvent) {
+	projectID := event.Project.ID
+	sha := event.ObjectAttributes.SHA
+	ref := event.ObjectAttributes.Ref
+	status := event.ObjectAttributes.Status
+
+	reqLog := log.With().
+		Int("project_id", projectID).
+		Str("sha", sha).
+		Str("status", status).
+		Logger()
+
+	reqLog.Debug().Msg("Pipeline event received")
+
+	if !isPipelineComplete(status) {
+		reqLog.Debug().Msg("Pipeline hasn't finished yet, skipping coverage finalization")
+		return
+	}
+
+	ctx = detachedContext(ctx, reqLog)
+
+	go finalizeCommitCoverageAndNotify(ctx, projectID, sha, ref)
+}
+
+func isPipelineComplete(status string) bool {
+	switch gitlab.BuildStateValue(status) {
+	case gitlab.Success, gitlab.Failed, gitlab.Canceled:
+		return true
+	default:
+		return false
+	}
+}
+
+func finalizeCommitCoverageAndNotify(ctx context.Context, projectID int, sha, ref string) {
+	ctx, span := tracer.Start(ctx, "finalizeCommitCoverageAndNotify")
+	defer span.End()
+
+	log := zerolog.Ctx(ctx)
+
+	coverage, err := finalizeCommitCoverage(ctx, projectID, sha)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to store commit coverage")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		log.Error().Err(err).Msg("Failed to finalize commit coverage")
+		return
+	}
+
+	if coverage == 0 {
+		log.Debug().Msg("No job reported coverage for this commit")
 		return
 	}
 
-	log.Info().Float64("coverage", coverage).Msg("Coverage is stored")
+	log.Info().Float64("coverage", coverage).Msg("Commit coverage finalized")
+
+	recordCommitMeta(ctx, projectID, sha, ref)
 
 	log.Debug().Msg("Updating linked merge requests")
-	handleLinkedMergeRequests(projectID, event.Sha, log)
+	handleLinkedMergeRequests(ctx, projectID, sha)
 }
 
-func handleLinkedMergeRequests(projectID int, sha string, log *zerolog.Logger) {
-	var mergeRequestIDs []int
+// recordCommitMeta fetches sha's committed_date and stores it alongside ref,
+// so /projects/{id}/coverage/history and the coverage badge can reconstruct
+// a trend without needing another GitLab API call per request.
+func recordCommitMeta(ctx context.Context, projectID int, sha, ref string) {
+	log := zerolog.Ctx(ctx)
 
-	err := readFromCommitBucket(projectID, sha, func(commitBucket *bolt.Bucket) error {
-		linkedMergeRequestsBucket := commitBucket.Bucket([]byte("mrs"))
-		if linkedMergeRequestsBucket == nil {
-			return nil
-		}
+	start := time.Now()
+	commit, _, err := git.Commits.GetCommit(projectID, sha)
+	observeGitlabAPICall("GetCommit", start, err)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch commit")
+		return
+	}
 
-		return linkedMergeRequestsBucket.ForEach(func(mergeRequestIDStr, _ []byte) error {
-			mergeRequestID, _ := strconv.Atoi(string(mergeRequestIDStr))
-			mergeRequestIDs = append(mergeRequestIDs, mergeRequestID)
-			return nil
-		})
-	})
+	if commit.CommittedDate == nil {
+		return
+	}
 
+	if err := store.PutCommitMeta(ctx, projectID, sha, ref, *commit.CommittedDate); err != nil {
+		log.Error().Err(err).Msg("Failed to store commit metadata")
+	}
+}
+
+func handleLinkedMergeRequests(ctx context.Context, projectID int, sha string) {
+	log := zerolog.Ctx(ctx)
+
+	mergeRequestIDs, err := store.GetLinkedMRs(ctx, projectID, sha)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to get linked merge requests for commit")
 		return
@@ -200,15 +434,16 @@ func handleLinkedMergeRequests(projectID int, sha string, log *zerolog.Logger) {
 		Msg("Got linked MRs")
 
 	for _, mergeRequestID := range mergeRequestIDs {
-		log := log.With().Int("merge_request_id", mergeRequestID).Logger()
+		mrLog := log.With().Int("merge_request_id", mergeRequestID).Logger()
+		mrCtx := mrLog.WithContext(ctx)
 
-		beforeSHA, lastCommitSHA, err := getMergeRequestCommitsData(projectID, mergeRequestID)
+		beforeSHA, lastCommitSHA, targetBranch, err := store.GetMRCommits(mrCtx, projectID, mergeRequestID)
 		if err != nil {
-			log.Error().Err(err).Msg("Failed to get merge request commits data")
+			mrLog.Error().Err(err).Msg("Failed to get merge request commits data")
 			continue
 		}
 
-		log.Debug().
+		mrLog.Debug().
 			Str("before_sha", beforeSHA).
 			Str("after_sha", lastCommitSHA).
 			Msg("Got merge request commits data")
@@ -217,59 +452,32 @@ func handleLinkedMergeRequests(projectID int, sha string, log *zerolog.Logger) {
 			continue
 		}
 
-		handleDiscussionPosting(projectID, mergeRequestID, beforeSHA, lastCommitSHA, &log)
+		handleDiscussionPosting(mrCtx, projectID, mergeRequestID, beforeSHA, lastCommitSHA, targetBranch)
 	}
 }
 
-func storeCommitCoverage(projectID int, sha string, coverage float64) error {
-	return storeCommitData(projectID, sha, func(bucket *bolt.Bucket) error {
-		coverageStr := strconv.FormatFloat(coverage, 'f', -1, 64)
-		err := bucket.Put([]byte("coverage"), []byte(coverageStr))
-
-		if err != nil {
-			return fmt.Errorf("store commit coverage error: %s", err)
-		}
-
-		return nil
-	})
-}
-
-func storeMergeRequestToCommitLink(projectID int, mergeRequestID int, lastCommitSHA string) error {
-	return storeCommitData(projectID, lastCommitSHA, func(commitBucket *bolt.Bucket) error {
-		mergeRequestIDsBucket, err := commitBucket.CreateBucketIfNotExists([]byte("mrs"))
-		if err != nil {
-			return fmt.Errorf("create merge request IDs bucket error: %s", err)
-		}
-
-		// Only keys matter here, so put zero byte as value
-		err = mergeRequestIDsBucket.Put([]byte(strconv.Itoa(mergeRequestID)), []byte("\x00"))
-		if err != nil {
-			return fmt.Errorf("store merge request ID into commit error: %s", err)
-		}
-
-		return nil
-	})
-}
-
-func storeCommitData(projectID int, sha string, storeFn func(*bolt.Bucket) error) error {
-	return db.Update(func(tx *bolt.Tx) error {
-		projectBucket, err := tx.CreateBucketIfNotExists([]byte(fmt.Sprintf("projects:%d", projectID)))
-		if err != nil {
-			return fmt.Errorf("create bucket: %s", err)
-		}
+func processMergeRequest(ctx context.Context, projectID int, mergeRequestID int) {
+	ctx, span := tracer.Start(ctx, "processMergeRequest")
+	defer span.End()
 
-		commitBucket, err := projectBucket.CreateBucketIfNotExists([]byte(fmt.Sprintf("sha:%s", sha)))
-		if err != nil {
-			return fmt.Errorf("create bucket: %s", err)
-		}
+	log := zerolog.Ctx(ctx)
 
-		return storeFn(commitBucket)
-	})
-}
+	start := time.Now()
+	mergeRequest, _, err := git.MergeRequests.GetMergeRequest(projectID, mergeRequestID, nil)
+	observeGitlabAPICall("GetMergeRequest", start, err)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		log.Error().Err(err).Msg("Failed to fetch merge request")
+		return
+	}
 
-func processMergeRequest(projectID int, mergeRequestID int, log *zerolog.Logger) {
+	start = time.Now()
 	commits, _, err := git.MergeRequests.GetMergeRequestCommits(projectID, mergeRequestID, nil)
+	observeGitlabAPICall("GetMergeRequestCommits", start, err)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		log.Error().Err(err).Msg("Failed to fetch merge request commits")
 		return
 	}
@@ -278,13 +486,13 @@ func processMergeRequest(projectID int, mergeRequestID int, log *zerolog.Logger)
 	lastCommit := commits[0]
 	commitBeforeMergeRequestSHA := firstCommit.ParentIDs[0]
 
-	err = storeMergeRequestData(projectID, mergeRequestID, commitBeforeMergeRequestSHA, lastCommit.ID)
+	err = store.PutMRData(ctx, projectID, mergeRequestID, commitBeforeMergeRequestSHA, lastCommit.ID, mergeRequest.TargetBranch)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to store merge request data")
 		return
 	}
 
-	err = storeMergeRequestToCommitLink(projectID, mergeRequestID, lastCommit.ID)
+	err = store.LinkMR(ctx, projectID, mergeRequestID, lastCommit.ID)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to store merge request to commit link")
 		return
@@ -292,54 +500,16 @@ func processMergeRequest(projectID int, mergeRequestID int, log *zerolog.Logger)
 
 	log.Info().Msg("Merge request stored")
 
-	handleDiscussionPosting(projectID, mergeRequestID, commitBeforeMergeRequestSHA, lastCommit.ID, log)
-}
-
-func storeMergeRequestData(projectID int, mergeRequestID int, beforeCommitSHA, lastCommitSHA string) error {
-	return storeInMergeRequestBucket(projectID, mergeRequestID, func(mergeRequestBucket *bolt.Bucket) error {
-		err := mergeRequestBucket.Put([]byte("beforeSHA"), []byte(beforeCommitSHA))
-		if err != nil {
-			return fmt.Errorf("storing before commit SHA: %s", err)
-		}
-
-		err = mergeRequestBucket.Put([]byte("lastCommitSHA"), []byte(lastCommitSHA))
-		if err != nil {
-			return fmt.Errorf("storing last commit SHA: %s", err)
-		}
-
-		return nil
-	})
+	handleDiscussionPosting(ctx, projectID, mergeRequestID, commitBeforeMergeRequestSHA, lastCommit.ID, mergeRequest.TargetBranch)
 }
 
-// Returns 0 if coverage doesn't stored.
-// Returns error only if coverage retrieving is failed.
-func getCommitCoverage(projectID int, sha string) (float64, error) {
-	var coverage float64
-
-	err := readFromCommitBucket(projectID, sha, func(commitBucket *bolt.Bucket) error {
-		coverageBytes := commitBucket.Get([]byte("coverage"))
-		if coverageBytes == nil {
-			return nil
-		}
-
-		var err error
-		coverage, err = strconv.ParseFloat(string(coverageBytes), 64)
-		if err != nil {
-			return fmt.Errorf("error while parsing coverage %q from db: %s", coverageBytes, err)
-		}
-
-		return nil
-	})
-
-	if err != nil {
-		return 0, err
-	}
+func handleDiscussionPosting(ctx context.Context, projectID int, mergeRequestID int, beforeCommitSHA, lastCommitSHA, targetBranch string) {
+	ctx, span := tracer.Start(ctx, "handleDiscussionPosting")
+	defer span.End()
 
-	return coverage, nil
-}
+	log := zerolog.Ctx(ctx)
 
-func handleDiscussionPosting(projectID int, mergeRequestID int, beforeCommitSHA, lastCommitSHA string, log *zerolog.Logger) {
-	coverageBefore, err := getCommitCoverage(projectID, beforeCommitSHA)
+	coverageBefore, err := store.GetCoverage(ctx, projectID, beforeCommitSHA)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to get coverage before merge request")
 	}
@@ -348,7 +518,7 @@ func handleDiscussionPosting(projectID int, mergeRequestID int, beforeCommitSHA,
 		Float64("coverage", coverageBefore).
 		Msg("Coverage before")
 
-	coverageAfter, err := getCommitCoverage(projectID, lastCommitSHA)
+	coverageAfter, err := store.GetCoverage(ctx, projectID, lastCommitSHA)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to get coverage after merge request")
 	}
@@ -357,13 +527,57 @@ func handleDiscussionPosting(projectID int, mergeRequestID int, beforeCommitSHA,
 		Float64("coverage", coverageAfter).
 		Msg("Coverage after")
 
-	postOrUpdateCoverageMessage(projectID, mergeRequestID, coverageBefore, coverageAfter, log)
+	if coverageBefore != 0 && coverageAfter != 0 {
+		coverageDelta.Observe(coverageAfter - coverageBefore)
+	}
+
+	jobs, err := store.GetJobCoverages(ctx, projectID, lastCommitSHA)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get per-job coverage breakdown")
+	}
+
+	postOrUpdateCoverageMessage(ctx, projectID, mergeRequestID, coverageBefore, coverageAfter, jobs)
+
+	postUncoveredLineDiscussions(ctx, projectID, mergeRequestID, lastCommitSHA)
+
+	enforceCoveragePolicy(ctx, projectID, lastCommitSHA, targetBranch, coverageBefore, coverageAfter)
 }
 
-func postOrUpdateCoverageMessage(projectID, mergeRequestID int, coverageBefore, coverageAfter float64, log *zerolog.Logger) {
-	message := noteMessage(coverageBefore, coverageAfter)
+// enforceCoveragePolicy resolves the project's coverage policy, if any, and
+// posts a commit status reflecting whether the merge request passes it.
+func enforceCoveragePolicy(ctx context.Context, projectID int, sha, targetBranch string, coverageBefore, coverageAfter float64) {
+	log := zerolog.Ctx(ctx)
 
-	existingNoteID, err := getNoteID(projectID, mergeRequestID)
+	if coverageAfter == 0 {
+		return
+	}
+
+	policy, err := getCoveragePolicy(ctx, projectID, targetBranch)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load coverage policy")
+		return
+	}
+
+	if policy == nil || !policy.appliesToBranch(targetBranch) {
+		return
+	}
+
+	pass, reason := policy.evaluate(coverageBefore, coverageAfter)
+	if pass {
+		reason = fmt.Sprintf("coverage %.2f%% meets the policy", coverageAfter)
+	}
+
+	if err := postCommitStatus(ctx, projectID, sha, pass, reason); err != nil {
+		log.Error().Err(err).Msg("Failed to post commit status")
+	}
+}
+
+func postOrUpdateCoverageMessage(ctx context.Context, projectID, mergeRequestID int, coverageBefore, coverageAfter float64, jobs []JobCoverage) {
+	log := zerolog.Ctx(ctx)
+
+	message := noteMessage(coverageBefore, coverageAfter) + jobsCoverageTable(jobs)
+
+	existingNoteID, err := store.GetNoteID(ctx, projectID, mergeRequestID)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to get existing note ID")
 	}
@@ -371,31 +585,35 @@ func postOrUpdateCoverageMessage(projectID, mergeRequestID int, coverageBefore,
 	if existingNoteID == 0 {
 		log.Info().Msg("Posting new note")
 
-		noteID, err := postCoverageMessage(projectID, mergeRequestID, message, log)
+		noteID, err := postCoverageMessage(ctx, projectID, mergeRequestID, message)
 		if err != nil {
 			log.Error().Err(err).Msg("Cannot create note on merge request")
 		}
 
-		err = storeNoteID(projectID, mergeRequestID, noteID)
+		err = store.PutNoteID(ctx, projectID, mergeRequestID, noteID)
 		if err != nil {
 			log.Error().Err(err).Msg("Failed to store new note ID")
 		}
 	} else {
 		log.Info().Int("note_id", existingNoteID).Msg("Modifying existing note")
 
-		err := updateCoverageMessage(projectID, mergeRequestID, existingNoteID, message, log)
+		err := updateCoverageMessage(ctx, projectID, mergeRequestID, existingNoteID, message)
 		if err != nil {
 			log.Error().Err(err).Msg("Cannot update note on merge request")
 		}
 	}
 }
 
-func postCoverageMessage(projectID, mergeRequestID int, message string, log *zerolog.Logger) (int, error) {
+func postCoverageMessage(ctx context.Context, projectID, mergeRequestID int, message string) (int, error) {
+	log := zerolog.Ctx(ctx)
+
 	noteOpts := &gitlab.CreateMergeRequestNoteOptions{
 		Body: gitlab.String(message),
 	}
 
+	start := time.Now()
 	note, _, err := git.Notes.CreateMergeRequestNote(projectID, mergeRequestID, noteOpts)
+	observeGitlabAPICall("CreateMergeRequestNote", start, err)
 	if err != nil {
 		return 0, err
 	}
@@ -408,12 +626,16 @@ func postCoverageMessage(projectID, mergeRequestID int, message string, log *zer
 	return note.ID, nil
 }
 
-func updateCoverageMessage(projectID, mergeRequestID, noteID int, message string, log *zerolog.Logger) error {
+func updateCoverageMessage(ctx context.Context, projectID, mergeRequestID, noteID int, message string) error {
+	log := zerolog.Ctx(ctx)
+
 	noteOpts := &gitlab.UpdateMergeRequestNoteOptions{
 		Body: gitlab.String(message),
 	}
 
+	start := time.Now()
 	note, _, err := git.Notes.UpdateMergeRequestNote(projectID, mergeRequestID, noteID, noteOpts)
+	observeGitlabAPICall("UpdateMergeRequestNote", start, err)
 	if err != nil {
 		return err
 	}
@@ -448,101 +670,3 @@ func noteMessage(coverageBefore, coverageAfter float64) string {
 
 	return fmt.Sprintf("**Coverage reporter**  \n%s", message)
 }
-
-func storeNoteID(projectID, mergeRequestID, noteID int) error {
-	return storeInMergeRequestBucket(projectID, mergeRequestID, func(mergeRequestBucket *bolt.Bucket) error {
-		err := mergeRequestBucket.Put([]byte("note_id"), []byte(strconv.Itoa(noteID)))
-		if err != nil {
-			return fmt.Errorf("storing note ID error: %s", err)
-		}
-		return nil
-	})
-}
-
-func storeInMergeRequestBucket(projectID int, mergeRequestID int, storeFn func(*bolt.Bucket) error) error {
-	return db.Update(func(tx *bolt.Tx) error {
-		projectBucket, err := tx.CreateBucketIfNotExists([]byte(fmt.Sprintf("projects:%d", projectID)))
-		if err != nil {
-			return fmt.Errorf("create bucket: %s", err)
-		}
-
-		mergeRequestBucket, err := projectBucket.
-			CreateBucketIfNotExists([]byte(fmt.Sprintf("mr:%d", mergeRequestID)))
-		if err != nil {
-			return fmt.Errorf("create bucket: %s", err)
-		}
-
-		return storeFn(mergeRequestBucket)
-	})
-}
-
-func getNoteID(projectID, mergeRequestID int) (int, error) {
-	var noteID int
-
-	err := readFromMergeRequestBucket(projectID, mergeRequestID, func(mergeRequestBucket *bolt.Bucket) error {
-		noteIDBytes := mergeRequestBucket.Get([]byte("note_id"))
-		if noteIDBytes == nil {
-			return nil
-		}
-
-		var err error
-		noteID, err = strconv.Atoi(string(noteIDBytes))
-		if err != nil {
-			return err
-		}
-
-		return nil
-	})
-
-	return noteID, err
-}
-
-func getMergeRequestCommitsData(projectID, mergeRequestID int) (beforeSHA, lastSHA string, err error) {
-	err = readFromMergeRequestBucket(projectID, mergeRequestID, func(mergeRequestBucket *bolt.Bucket) error {
-		sha := mergeRequestBucket.Get([]byte("beforeSHA"))
-		if sha != nil {
-			beforeSHA = string(sha)
-		}
-
-		sha = mergeRequestBucket.Get([]byte("lastCommitSHA"))
-		if sha != nil {
-			lastSHA = string(sha)
-		}
-
-		return nil
-	})
-
-	return
-}
-
-func readFromMergeRequestBucket(projectID int, mergeRequestID int, readFn func(*bolt.Bucket) error) error {
-	return db.View(func(tx *bolt.Tx) error {
-		projectBucket := tx.Bucket([]byte(fmt.Sprintf("projects:%d", projectID)))
-		if projectBucket == nil {
-			return nil
-		}
-
-		mergeRequestBucket := projectBucket.Bucket([]byte(fmt.Sprintf("mr:%d", mergeRequestID)))
-		if mergeRequestBucket == nil {
-			return nil
-		}
-
-		return readFn(mergeRequestBucket)
-	})
-}
-
-func readFromCommitBucket(projectID int, sha string, readFn func(*bolt.Bucket) error) error {
-	return db.View(func(tx *bolt.Tx) error {
-		projectBucket := tx.Bucket([]byte(fmt.Sprintf("projects:%d", projectID)))
-		if projectBucket == nil {
-			return nil
-		}
-
-		commitBucket := projectBucket.Bucket([]byte(fmt.Sprintf("sha:%s", sha)))
-		if commitBucket == nil {
-			return nil
-		}
-
-		return readFn(commitBucket)
-	})
-}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	webhookEventsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitlab_review_coverage_webhook_events_total",
+		Help: "Number of webhook events received, by event type.",
+	}, []string{"event_type"})
+
+	gitlabAPICallDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gitlab_review_coverage_gitlab_api_call_duration_seconds",
+		Help:    "Latency of calls made to the GitLab API, by method and outcome.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "outcome"})
+
+	storeTxDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gitlab_review_coverage_store_tx_duration_seconds",
+		Help:    "Duration of Store operations, by backend and operation.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"backend", "operation"})
+
+	coverageDelta = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "gitlab_review_coverage_coverage_delta",
+		Help:    "Distribution of the coverage change (after minus before) observed across merge requests.",
+		Buckets: []float64{-10, -5, -2, -1, -0.5, 0, 0.5, 1, 2, 5, 10},
+	})
+)
+
+// registerMetricsHandler exposes the Prometheus metrics on /metrics.
+func registerMetricsHandler(mux *http.ServeMux) {
+	mux.Handle("/metrics", promhttp.Handler())
+}
+
+// observeGitlabAPICall records the latency and outcome of a single GitLab
+// API call. Call it with the time the call started and the error it
+// returned, right after the call completes.
+func observeGitlabAPICall(method string, start time.Time, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+	}
+
+	gitlabAPICallDuration.WithLabelValues(method, outcome).Observe(time.Since(start).Seconds())
+}
+
+// observeStoreTx records how long a Store operation took against a given
+// backend ("bolt" or "postgres").
+func observeStoreTx(backend, operation string, start time.Time) {
+	storeTxDuration.WithLabelValues(backend, operation).Observe(time.Since(start).Seconds())
+}
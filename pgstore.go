@@ -0,0 +1,428 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// pgStore is a PostgreSQL-backed Store. Unlike boltStore it holds no
+// exclusive lock on anything, so any number of webhook receiver replicas can
+// share the same database behind a load balancer.
+type pgStore struct {
+	pool *pgxpool.Pool
+}
+
+const pgSchema = `
+CREATE TABLE IF NOT EXISTS commit_coverage (
+	project_id BIGINT NOT NULL,
+	sha TEXT NOT NULL,
+	coverage DOUBLE PRECISION NOT NULL,
+	ref TEXT NOT NULL DEFAULT '',
+	committed_date TIMESTAMPTZ,
+	PRIMARY KEY (project_id, sha)
+);
+
+CREATE INDEX IF NOT EXISTS commit_coverage_ref_idx
+	ON commit_coverage (project_id, ref, committed_date);
+
+CREATE TABLE IF NOT EXISTS job_coverage (
+	project_id BIGINT NOT NULL,
+	sha TEXT NOT NULL,
+	job_id BIGINT NOT NULL,
+	name TEXT NOT NULL,
+	coverage DOUBLE PRECISION NOT NULL,
+	line_count BIGINT NOT NULL,
+	reported_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (project_id, sha, job_id)
+);
+
+CREATE TABLE IF NOT EXISTS file_coverage (
+	project_id BIGINT NOT NULL,
+	sha TEXT NOT NULL,
+	path TEXT NOT NULL,
+	lines JSONB NOT NULL,
+	PRIMARY KEY (project_id, sha, path)
+);
+
+CREATE TABLE IF NOT EXISTS commit_mr_links (
+	project_id BIGINT NOT NULL,
+	sha TEXT NOT NULL,
+	merge_request_id BIGINT NOT NULL,
+	PRIMARY KEY (project_id, sha, merge_request_id)
+);
+
+CREATE TABLE IF NOT EXISTS merge_requests (
+	project_id BIGINT NOT NULL,
+	merge_request_id BIGINT NOT NULL,
+	before_sha TEXT NOT NULL DEFAULT '',
+	last_commit_sha TEXT NOT NULL DEFAULT '',
+	target_branch TEXT NOT NULL DEFAULT '',
+	note_id BIGINT NOT NULL DEFAULT 0,
+	PRIMARY KEY (project_id, merge_request_id)
+);
+
+CREATE TABLE IF NOT EXISTS posted_discussions (
+	project_id BIGINT NOT NULL,
+	merge_request_id BIGINT NOT NULL,
+	path TEXT NOT NULL,
+	line BIGINT NOT NULL,
+	PRIMARY KEY (project_id, merge_request_id, path, line)
+);
+`
+
+func newPgStore(databaseURL string) (*pgStore, error) {
+	ctx := context.Background()
+
+	pool, err := pgxpool.New(ctx, databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("connect to postgres: %s", err)
+	}
+
+	if _, err := pool.Exec(ctx, pgSchema); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("apply postgres schema: %s", err)
+	}
+
+	return &pgStore{pool: pool}, nil
+}
+
+func (s *pgStore) Close() error {
+	s.pool.Close()
+	return nil
+}
+
+func (s *pgStore) PutCoverage(ctx context.Context, projectID int, sha string, coverage float64) error {
+	defer observeStoreTx("postgres", "put_coverage", time.Now())
+
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO commit_coverage (project_id, sha, coverage)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (project_id, sha) DO UPDATE SET coverage = EXCLUDED.coverage
+	`, projectID, sha, coverage)
+	if err != nil {
+		return fmt.Errorf("store commit coverage error: %s", err)
+	}
+
+	return nil
+}
+
+// GetCoverage returns 0 without error if no coverage is stored for sha.
+func (s *pgStore) GetCoverage(ctx context.Context, projectID int, sha string) (float64, error) {
+	defer observeStoreTx("postgres", "get_coverage", time.Now())
+
+	var coverage float64
+	err := s.pool.QueryRow(ctx, `
+		SELECT coverage FROM commit_coverage WHERE project_id = $1 AND sha = $2
+	`, projectID, sha).Scan(&coverage)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("get commit coverage error: %s", err)
+	}
+
+	return coverage, nil
+}
+
+func (s *pgStore) PutCommitMeta(ctx context.Context, projectID int, sha, ref string, committedDate time.Time) error {
+	defer observeStoreTx("postgres", "put_commit_meta", time.Now())
+
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO commit_coverage (project_id, sha, coverage, ref, committed_date)
+		VALUES ($1, $2, 0, $3, $4)
+		ON CONFLICT (project_id, sha) DO UPDATE SET ref = EXCLUDED.ref, committed_date = EXCLUDED.committed_date
+	`, projectID, sha, ref, committedDate)
+	if err != nil {
+		return fmt.Errorf("store commit metadata error: %s", err)
+	}
+
+	return nil
+}
+
+// GetCoverageHistory returns ref's finalized commit coverage, oldest first,
+// for every commit committed on or after since.
+func (s *pgStore) GetCoverageHistory(ctx context.Context, projectID int, ref string, since time.Time) ([]CoverageHistoryEntry, error) {
+	defer observeStoreTx("postgres", "get_coverage_history", time.Now())
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT sha, committed_date, coverage FROM commit_coverage
+		WHERE project_id = $1 AND ref = $2 AND committed_date >= $3
+		ORDER BY committed_date ASC
+	`, projectID, ref, since)
+	if err != nil {
+		return nil, fmt.Errorf("get coverage history error: %s", err)
+	}
+	defer rows.Close()
+
+	var history []CoverageHistoryEntry
+	for rows.Next() {
+		var entry CoverageHistoryEntry
+		if err := rows.Scan(&entry.SHA, &entry.Timestamp, &entry.Coverage); err != nil {
+			return nil, fmt.Errorf("scan coverage history entry error: %s", err)
+		}
+		history = append(history, entry)
+	}
+
+	return history, rows.Err()
+}
+
+func (s *pgStore) PutJobCoverage(ctx context.Context, projectID int, sha string, job JobCoverage) error {
+	defer observeStoreTx("postgres", "put_job_coverage", time.Now())
+
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO job_coverage (project_id, sha, job_id, name, coverage, line_count, reported_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (project_id, sha, job_id) DO UPDATE SET
+			name = EXCLUDED.name,
+			coverage = EXCLUDED.coverage,
+			line_count = EXCLUDED.line_count,
+			reported_at = EXCLUDED.reported_at
+	`, projectID, sha, job.JobID, job.Name, job.Coverage, job.LineCount, job.Timestamp)
+	if err != nil {
+		return fmt.Errorf("store job coverage error: %s", err)
+	}
+
+	return nil
+}
+
+// GetJobCoverages returns every job coverage report stored for sha, sorted
+// by job name for stable note rendering.
+func (s *pgStore) GetJobCoverages(ctx context.Context, projectID int, sha string) ([]JobCoverage, error) {
+	defer observeStoreTx("postgres", "get_job_coverages", time.Now())
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT job_id, name, coverage, line_count, reported_at
+		FROM job_coverage WHERE project_id = $1 AND sha = $2
+	`, projectID, sha)
+	if err != nil {
+		return nil, fmt.Errorf("get job coverages error: %s", err)
+	}
+	defer rows.Close()
+
+	var jobs []JobCoverage
+	for rows.Next() {
+		var job JobCoverage
+		if err := rows.Scan(&job.JobID, &job.Name, &job.Coverage, &job.LineCount, &job.Timestamp); err != nil {
+			return nil, fmt.Errorf("scan job coverage error: %s", err)
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("get job coverages error: %s", err)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
+
+	return jobs, nil
+}
+
+// PutFileCoverage merges hits into the per-file coverage already stored for
+// sha, so reports from multiple jobs accumulate instead of overwriting each
+// other.
+func (s *pgStore) PutFileCoverage(ctx context.Context, projectID int, sha string, hits fileLineHits) error {
+	if len(hits) == 0 {
+		return nil
+	}
+
+	defer observeStoreTx("postgres", "put_file_coverage", time.Now())
+
+	for path, lines := range hits {
+		existing, err := s.GetFileCoverage(ctx, projectID, sha, path)
+		if err != nil {
+			return err
+		}
+		if existing == nil {
+			existing = &FileCoverage{Lines: map[int]int{}}
+		}
+
+		for line, fileHits := range lines {
+			if fileHits > existing.Lines[line] {
+				existing.Lines[line] = fileHits
+			}
+		}
+
+		data, err := json.Marshal(existing.Lines)
+		if err != nil {
+			return fmt.Errorf("marshal file coverage error: %s", err)
+		}
+
+		_, err = s.pool.Exec(ctx, `
+			INSERT INTO file_coverage (project_id, sha, path, lines)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (project_id, sha, path) DO UPDATE SET lines = EXCLUDED.lines
+		`, projectID, sha, path, data)
+		if err != nil {
+			return fmt.Errorf("store file coverage error: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// GetFileCoverage returns the merged per-line coverage recorded for path at
+// sha, or nil if no job reported on that file.
+func (s *pgStore) GetFileCoverage(ctx context.Context, projectID int, sha, path string) (*FileCoverage, error) {
+	defer observeStoreTx("postgres", "get_file_coverage", time.Now())
+
+	var data []byte
+	err := s.pool.QueryRow(ctx, `
+		SELECT lines FROM file_coverage WHERE project_id = $1 AND sha = $2 AND path = $3
+	`, projectID, sha, path).Scan(&data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get file coverage error: %s", err)
+	}
+
+	var lines map[int]int
+	if err := json.Unmarshal(data, &lines); err != nil {
+		return nil, fmt.Errorf("unmarshal file coverage error: %s", err)
+	}
+
+	return &FileCoverage{Lines: lines}, nil
+}
+
+func (s *pgStore) LinkMR(ctx context.Context, projectID, mergeRequestID int, sha string) error {
+	defer observeStoreTx("postgres", "link_mr", time.Now())
+
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO commit_mr_links (project_id, sha, merge_request_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+	`, projectID, sha, mergeRequestID)
+	if err != nil {
+		return fmt.Errorf("store merge request ID into commit error: %s", err)
+	}
+
+	return nil
+}
+
+func (s *pgStore) GetLinkedMRs(ctx context.Context, projectID int, sha string) ([]int, error) {
+	defer observeStoreTx("postgres", "get_linked_mrs", time.Now())
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT merge_request_id FROM commit_mr_links WHERE project_id = $1 AND sha = $2
+	`, projectID, sha)
+	if err != nil {
+		return nil, fmt.Errorf("get linked merge requests error: %s", err)
+	}
+	defer rows.Close()
+
+	var mergeRequestIDs []int
+	for rows.Next() {
+		var mergeRequestID int
+		if err := rows.Scan(&mergeRequestID); err != nil {
+			return nil, fmt.Errorf("scan linked merge request error: %s", err)
+		}
+		mergeRequestIDs = append(mergeRequestIDs, mergeRequestID)
+	}
+
+	return mergeRequestIDs, rows.Err()
+}
+
+func (s *pgStore) PutMRData(ctx context.Context, projectID, mergeRequestID int, beforeSHA, lastCommitSHA, targetBranch string) error {
+	defer observeStoreTx("postgres", "put_mr_data", time.Now())
+
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO merge_requests (project_id, merge_request_id, before_sha, last_commit_sha, target_branch)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (project_id, merge_request_id) DO UPDATE SET
+			before_sha = EXCLUDED.before_sha,
+			last_commit_sha = EXCLUDED.last_commit_sha,
+			target_branch = EXCLUDED.target_branch
+	`, projectID, mergeRequestID, beforeSHA, lastCommitSHA, targetBranch)
+	if err != nil {
+		return fmt.Errorf("storing merge request data: %s", err)
+	}
+
+	return nil
+}
+
+func (s *pgStore) GetMRCommits(ctx context.Context, projectID, mergeRequestID int) (beforeSHA, lastCommitSHA, targetBranch string, err error) {
+	defer observeStoreTx("postgres", "get_mr_commits", time.Now())
+
+	err = s.pool.QueryRow(ctx, `
+		SELECT before_sha, last_commit_sha, target_branch
+		FROM merge_requests WHERE project_id = $1 AND merge_request_id = $2
+	`, projectID, mergeRequestID).Scan(&beforeSHA, &lastCommitSHA, &targetBranch)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", "", "", nil
+	}
+	if err != nil {
+		return "", "", "", fmt.Errorf("get merge request commits error: %s", err)
+	}
+
+	return beforeSHA, lastCommitSHA, targetBranch, nil
+}
+
+func (s *pgStore) PutNoteID(ctx context.Context, projectID, mergeRequestID, noteID int) error {
+	defer observeStoreTx("postgres", "put_note_id", time.Now())
+
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO merge_requests (project_id, merge_request_id, note_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (project_id, merge_request_id) DO UPDATE SET note_id = EXCLUDED.note_id
+	`, projectID, mergeRequestID, noteID)
+	if err != nil {
+		return fmt.Errorf("storing note ID error: %s", err)
+	}
+
+	return nil
+}
+
+func (s *pgStore) GetNoteID(ctx context.Context, projectID, mergeRequestID int) (int, error) {
+	defer observeStoreTx("postgres", "get_note_id", time.Now())
+
+	var noteID int
+	err := s.pool.QueryRow(ctx, `
+		SELECT note_id FROM merge_requests WHERE project_id = $1 AND merge_request_id = $2
+	`, projectID, mergeRequestID).Scan(&noteID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("get note ID error: %s", err)
+	}
+
+	return noteID, nil
+}
+
+func (s *pgStore) IsDiscussionPosted(ctx context.Context, projectID, mergeRequestID int, path string, line int) (bool, error) {
+	defer observeStoreTx("postgres", "is_discussion_posted", time.Now())
+
+	var exists bool
+	err := s.pool.QueryRow(ctx, `
+		SELECT EXISTS(
+			SELECT 1 FROM posted_discussions
+			WHERE project_id = $1 AND merge_request_id = $2 AND path = $3 AND line = $4
+		)
+	`, projectID, mergeRequestID, path, line).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("check discussion state error: %s", err)
+	}
+
+	return exists, nil
+}
+
+func (s *pgStore) MarkDiscussionPosted(ctx context.Context, projectID, mergeRequestID int, path string, line int) error {
+	defer observeStoreTx("postgres", "mark_discussion_posted", time.Now())
+
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO posted_discussions (project_id, merge_request_id, path, line)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING
+	`, projectID, mergeRequestID, path, line)
+	if err != nil {
+		return fmt.Errorf("store discussion marker error: %s", err)
+	}
+
+	return nil
+}
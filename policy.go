@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/xanzy/go-gitlab"
+	"gopkg.in/yaml.v2"
+)
+
+const repoPolicyFilePath = ".gitlab-review-coverage.yml"
+
+// CoveragePolicy describes the coverage gate a project opts into, either via
+// a per-project override in config.yaml or a .gitlab-review-coverage.yml
+// committed to the repository itself.
+type CoveragePolicy struct {
+	MinCoverage    float64  `yaml:"min_coverage"`
+	MaxDecrease    float64  `yaml:"max_decrease"`
+	TargetBranches []string `yaml:"target_branches"`
+}
+
+// appliesToBranch reports whether the policy should be enforced for the
+// given target branch. An empty TargetBranches list means "all branches".
+func (p *CoveragePolicy) appliesToBranch(branch string) bool {
+	if p == nil || len(p.TargetBranches) == 0 {
+		return true
+	}
+
+	for _, targetBranch := range p.TargetBranches {
+		if targetBranch == branch {
+			return true
+		}
+	}
+
+	return false
+}
+
+// evaluate checks coverageAfter/coverageBefore against the policy and
+// returns whether the change passes along with a human-readable reason.
+func (p *CoveragePolicy) evaluate(coverageBefore, coverageAfter float64) (pass bool, reason string) {
+	if p.MinCoverage > 0 && coverageAfter < p.MinCoverage {
+		return false, fmt.Sprintf("coverage %.2f%% is below the required minimum of %.2f%%", coverageAfter, p.MinCoverage)
+	}
+
+	if p.MaxDecrease > 0 && coverageBefore > 0 {
+		decrease := coverageBefore - coverageAfter
+		if decrease > p.MaxDecrease {
+			return false, fmt.Sprintf("coverage dropped by %.2f%%, which exceeds the allowed %.2f%%", decrease, p.MaxDecrease)
+		}
+	}
+
+	return true, ""
+}
+
+// getCoveragePolicy resolves the policy for a project: a config.yaml
+// override takes precedence, falling back to .gitlab-review-coverage.yml
+// fetched from the target branch. A nil policy means no gate is configured.
+func getCoveragePolicy(ctx context.Context, projectID int, ref string) (*CoveragePolicy, error) {
+	if projectCfg := config.projectConfig(projectID); projectCfg != nil && projectCfg.Policy != nil {
+		return projectCfg.Policy, nil
+	}
+
+	return fetchCoveragePolicyFromRepo(ctx, projectID, ref)
+}
+
+func fetchCoveragePolicyFromRepo(ctx context.Context, projectID int, ref string) (*CoveragePolicy, error) {
+	opts := &gitlab.GetRawFileOptions{Ref: gitlab.String(ref)}
+
+	start := time.Now()
+	data, resp, err := git.RepositoryFiles.GetRawFile(projectID, repoPolicyFilePath, opts)
+	observeGitlabAPICall("GetRawFile", start, err)
+	if err != nil {
+		if resp != nil && resp.StatusCode == 404 {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var policy CoveragePolicy
+	if err := yaml.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("parsing %s: %s", repoPolicyFilePath, err)
+	}
+
+	return &policy, nil
+}
+
+// postCommitStatus reports pass/fail as a GitLab commit status so the
+// policy can actually block a merge request, not just comment on it.
+func postCommitStatus(ctx context.Context, projectID int, sha string, pass bool, description string) error {
+	log := zerolog.Ctx(ctx)
+
+	state := gitlab.Success
+	if !pass {
+		state = gitlab.Failed
+	}
+
+	opts := &gitlab.SetCommitStatusOptions{
+		State:       state,
+		Name:        gitlab.String("coverage"),
+		Context:     gitlab.String("coverage"),
+		Description: gitlab.String(description),
+	}
+
+	start := time.Now()
+	_, _, err := git.Commits.SetCommitStatus(projectID, sha, opts)
+	observeGitlabAPICall("SetCommitStatus", start, err)
+	if err != nil {
+		return err
+	}
+
+	log.Info().
+		Str("state", string(state)).
+		Str("description", description).
+		Msg("Posted commit status")
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import "testing"
+
+func TestCoveragePolicyEvaluate(t *testing.T) {
+	tests := []struct {
+		name            string
+		policy          CoveragePolicy
+		before          float64
+		after           float64
+		wantPass        bool
+		wantReasonEmpty bool
+	}{
+		{
+			name:            "no thresholds configured always passes",
+			policy:          CoveragePolicy{},
+			before:          90,
+			after:           10,
+			wantPass:        true,
+			wantReasonEmpty: true,
+		},
+		{
+			name:            "meets minimum coverage",
+			policy:          CoveragePolicy{MinCoverage: 80},
+			before:          0,
+			after:           80,
+			wantPass:        true,
+			wantReasonEmpty: true,
+		},
+		{
+			name:     "below minimum coverage",
+			policy:   CoveragePolicy{MinCoverage: 80},
+			before:   0,
+			after:    79.9,
+			wantPass: false,
+		},
+		{
+			name:            "decrease within allowance",
+			policy:          CoveragePolicy{MaxDecrease: 5},
+			before:          90,
+			after:           86,
+			wantPass:        true,
+			wantReasonEmpty: true,
+		},
+		{
+			name:     "decrease exceeds allowance",
+			policy:   CoveragePolicy{MaxDecrease: 5},
+			before:   90,
+			after:    80,
+			wantPass: false,
+		},
+		{
+			name:            "no prior coverage skips the decrease check",
+			policy:          CoveragePolicy{MaxDecrease: 5},
+			before:          0,
+			after:           10,
+			wantPass:        true,
+			wantReasonEmpty: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pass, reason := tt.policy.evaluate(tt.before, tt.after)
+			if pass != tt.wantPass {
+				t.Errorf("evaluate() pass = %v, want %v (reason: %q)", pass, tt.wantPass, reason)
+			}
+			if tt.wantReasonEmpty && reason != "" {
+				t.Errorf("evaluate() reason = %q, want empty", reason)
+			}
+			if !tt.wantReasonEmpty && reason == "" {
+				t.Errorf("evaluate() reason = empty, want non-empty")
+			}
+		})
+	}
+}
+
+func TestCoveragePolicyAppliesToBranch(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy *CoveragePolicy
+		branch string
+		want   bool
+	}{
+		{
+			name:   "nil policy applies everywhere",
+			policy: nil,
+			branch: "main",
+			want:   true,
+		},
+		{
+			name:   "empty target branches applies everywhere",
+			policy: &CoveragePolicy{},
+			branch: "feature/x",
+			want:   true,
+		},
+		{
+			name:   "matching target branch",
+			policy: &CoveragePolicy{TargetBranches: []string{"main", "develop"}},
+			branch: "develop",
+			want:   true,
+		},
+		{
+			name:   "non-matching target branch",
+			policy: &CoveragePolicy{TargetBranches: []string{"main"}},
+			branch: "feature/x",
+			want:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.appliesToBranch(tt.branch); got != tt.want {
+				t.Errorf("appliesToBranch() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
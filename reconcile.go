@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+)
+
+func reconcileMRCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "reconcile-mr",
+		Usage: "re-compute and re-post the coverage note for a single merge request",
+		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:     "project",
+				Usage:    "GitLab project ID",
+				Required: true,
+			},
+			&cli.IntFlag{
+				Name:     "mr",
+				Usage:    "merge request IID",
+				Required: true,
+			},
+		},
+		Action: runReconcileMR,
+	}
+}
+
+func runReconcileMR(c *cli.Context) error {
+	projectID := c.Int("project")
+	mergeRequestID := c.Int("mr")
+
+	log.Info().
+		Int("project_id", projectID).
+		Int("merge_request_id", mergeRequestID).
+		Msg("Reconciling merge request")
+
+	reqLog := log.With().
+		Int("project_id", projectID).
+		Int("merge_request_id", mergeRequestID).
+		Logger()
+
+	processMergeRequest(reqLog.WithContext(context.Background()), projectID, mergeRequestID)
+
+	return nil
+}
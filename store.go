@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// storeBackendEnvVar selects which Store implementation the webhook
+// receiver runs against. The default, "bolt", keeps the existing single-file
+// on-disk database; "postgres" opens the horizontally-scalable pgstore
+// backend instead.
+const storeBackendEnvVar = "STORE_BACKEND"
+
+const defaultStoreBackend = "bolt"
+
+// Store persists everything the webhook receiver needs to remember between
+// events: commit coverage, per-job and per-file breakdowns, merge request
+// linkage, and posted note/discussion bookkeeping.
+//
+// bbolt's exclusive file lock means only one replica of the webhook receiver
+// can ever hold it open, which rules out running behind a load balancer.
+// Store exists so that limitation lives behind an interface: pgstore has no
+// such lock and can be shared by as many replicas as needed.
+type Store interface {
+	PutCoverage(ctx context.Context, projectID int, sha string, coverage float64) error
+	GetCoverage(ctx context.Context, projectID int, sha string) (float64, error)
+
+	PutCommitMeta(ctx context.Context, projectID int, sha, ref string, committedDate time.Time) error
+	GetCoverageHistory(ctx context.Context, projectID int, ref string, since time.Time) ([]CoverageHistoryEntry, error)
+
+	PutJobCoverage(ctx context.Context, projectID int, sha string, job JobCoverage) error
+	GetJobCoverages(ctx context.Context, projectID int, sha string) ([]JobCoverage, error)
+
+	PutFileCoverage(ctx context.Context, projectID int, sha string, hits fileLineHits) error
+	GetFileCoverage(ctx context.Context, projectID int, sha, path string) (*FileCoverage, error)
+
+	LinkMR(ctx context.Context, projectID, mergeRequestID int, sha string) error
+	GetLinkedMRs(ctx context.Context, projectID int, sha string) ([]int, error)
+
+	PutMRData(ctx context.Context, projectID, mergeRequestID int, beforeSHA, lastCommitSHA, targetBranch string) error
+	GetMRCommits(ctx context.Context, projectID, mergeRequestID int) (beforeSHA, lastCommitSHA, targetBranch string, err error)
+
+	PutNoteID(ctx context.Context, projectID, mergeRequestID, noteID int) error
+	GetNoteID(ctx context.Context, projectID, mergeRequestID int) (int, error)
+
+	IsDiscussionPosted(ctx context.Context, projectID, mergeRequestID int, path string, line int) (bool, error)
+	MarkDiscussionPosted(ctx context.Context, projectID, mergeRequestID int, path string, line int) error
+
+	Close() error
+}
+
+// newStore builds the Store selected by the STORE_BACKEND env var, defaulting
+// to the bbolt-backed implementation this service has always used.
+func newStore() (Store, error) {
+	switch backend := getEnvVarOrDefault(storeBackendEnvVar, defaultStoreBackend); backend {
+	case "bolt":
+		return newBoltStore(getRequiredEnvVar("BOLT_DB_PATH"))
+	case "postgres":
+		return newPgStore(getRequiredEnvVar("DATABASE_URL"))
+	default:
+		return nil, fmt.Errorf("unknown %s %q", storeBackendEnvVar, backend)
+	}
+}
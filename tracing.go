@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+const tracerName = "github.com/flexoid/gitlab-review-coverage"
+
+var tracer = otel.Tracer(tracerName)
+
+// initTracing wires up a global OTel tracer provider with an OTLP/gRPC
+// exporter. It's a no-op when OTEL_EXPORTER_OTLP_ENDPOINT isn't set, so
+// tracing stays entirely opt-in. The returned shutdown func flushes and
+// closes the exporter and should be deferred by the caller.
+func initTracing(ctx context.Context) (shutdown func(context.Context) error, err error) {
+	noop := func(context.Context) error { return nil }
+
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	if len(endpoint) == 0 {
+		return noop, nil
+	}
+
+	serviceName := getEnvVarOrDefault("OTEL_SERVICE_NAME", "gitlab-review-coverage")
+
+	samplerRatio := 1.0
+	if ratioStr := os.Getenv("OTEL_TRACES_SAMPLER_ARG"); len(ratioStr) > 0 {
+		parsedRatio, err := strconv.ParseFloat(ratioStr, 64)
+		if err != nil {
+			return noop, fmt.Errorf("parsing OTEL_TRACES_SAMPLER_ARG: %s", err)
+		}
+		samplerRatio = parsedRatio
+	}
+
+	exporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithEndpoint(endpoint), otlptracegrpc.WithInsecure())
+	if err != nil {
+		return noop, fmt.Errorf("creating OTLP trace exporter: %s", err)
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceNameKey.String(serviceName)),
+	)
+	if err != nil {
+		return noop, fmt.Errorf("creating OTel resource: %s", err)
+	}
+
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(samplerRatio))),
+		sdktrace.WithResource(res),
+	)
+
+	otel.SetTracerProvider(tracerProvider)
+
+	log.Info().
+		Str("endpoint", endpoint).
+		Float64("sampler_ratio", samplerRatio).
+		Msg("OpenTelemetry tracing enabled")
+
+	return tracerProvider.Shutdown, nil
+}